@@ -0,0 +1,776 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+//The JSON REST API, served under /api/v1.
+//Songs and songbooks are found by their link, i.e. the file name without
+//".song" or ".songlist". Errors are returned as an apiErrorBody.
+
+//apiSongSummary is a Song in a list of songs.
+type apiSongSummary struct {
+	Link  string `json:"link"`
+	Title string `json:"title"`
+}
+
+//apiSong is a Song, as displayed with the requested options.
+type apiSong struct {
+	Link           string       `json:"link"`
+	Title          string       `json:"title"`
+	Section        string       `json:"section,omitempty"`
+	Subtitles      []string     `json:"subtitles,omitempty"`
+	Artists        []string     `json:"artists,omitempty"`
+	Composers      []string     `json:"composers,omitempty"`
+	Lyricists      []string     `json:"lyricists,omitempty"`
+	Copyright      string       `json:"copyright,omitempty"`
+	Key            string       `json:"key,omitempty"`
+	OriginalKey    string       `json:"original_key,omitempty"`
+	Transpose      int          `json:"transpose"`
+	Capo           int          `json:"capo"`
+	BeforeComments []apiComment `json:"before_comments"`
+	AfterComments  []apiComment `json:"after_comments"`
+	Stanzas        []apiStanza  `json:"stanzas"`
+}
+
+type apiStanza struct {
+	Number         int          `json:"number"`
+	Kind           string       `json:"kind"`
+	Label          string       `json:"label,omitempty"`
+	Recall         bool         `json:"recall"`
+	ShowNumber     bool         `json:"show_number"`
+	BeforeComments []apiComment `json:"before_comments"`
+	AfterComments  []apiComment `json:"after_comments"`
+	Lines          []apiLine    `json:"lines"`
+}
+
+//apiLine is a Line, EchoIndex is -1 if the Line has no echo.
+type apiLine struct {
+	Text      string     `json:"text"`
+	EchoIndex int        `json:"echo_index"`
+	Chords    []apiChord `json:"chords"`
+}
+
+//apiChord is a Chord. Text is the Chord as displayed (e.g. with a capo or
+//as a number), Concert is the sounding chord, and Position is where the
+//Chord is placed in the Line's Text, in characters (runes).
+type apiChord struct {
+	Text     string `json:"text"`
+	Concert  string `json:"concert"`
+	Position int    `json:"position"`
+}
+
+type apiComment struct {
+	Text  string `json:"text"`
+	Style string `json:"style"`
+}
+
+//apiBook is a Songbook with its settings and songs in order.
+type apiBook struct {
+	Link          string        `json:"link"`
+	Title         string        `json:"title"`
+	FixedOrder    bool          `json:"fixed_order"`
+	UseSection    bool          `json:"index_use_sections"`
+	IndexChorus   bool          `json:"index_use_chorus"`
+	IndexPosition string        `json:"index_position"`
+	Naming        string        `json:"naming,omitempty"`
+	Songs         []apiBookSong `json:"songs"`
+}
+
+type apiBookSong struct {
+	Number int    `json:"number"`
+	Link   string `json:"link"`
+	Title  string `json:"title"`
+	Key    string `json:"key,omitempty"`
+}
+
+//apiContent is the body of a request creating or changing a song or
+//songbook. Name is only used when creating. Version is the version that was
+//changed (see fileStore.Version), if given the change is only saved if no
+//one else has changed the file since.
+type apiContent struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Message string `json:"message,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type apiErrorBody struct {
+	Error       string       `json:"error"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+//maxAPIBody is the largest request body accepted.
+const maxAPIBody = 1 << 20
+
+func registerAPI(r *httprouter.Router) {
+	r.GET("/api/v1/songs", apiListSongs)
+	r.POST("/api/v1/songs", apiWritable(RoleEditor, apiCreateSong))
+	r.GET("/api/v1/songs/:song", apiGetSong)
+	r.PUT("/api/v1/songs/:song", apiWritable(RoleEditor, apiUpdateSong))
+	r.DELETE("/api/v1/songs/:song", apiWritable(RoleAdmin, apiDeleteSong))
+	r.GET("/api/v1/songs/:song/render", apiRenderSong)
+	r.GET("/api/v1/search", apiSearch)
+
+	r.GET("/api/v1/books", apiListBooks)
+	r.POST("/api/v1/books", apiWritable(RoleEditor, apiCreateBook))
+	r.GET("/api/v1/books/:book", apiGetBook)
+	r.PUT("/api/v1/books/:book", apiWritable(RoleEditor, apiUpdateBook))
+	r.DELETE("/api/v1/books/:book", apiWritable(RoleAdmin, apiDeleteBook))
+	r.GET("/api/v1/books/:book/render", apiRenderBook)
+
+	r.GET("/api/v1/deleted", apiDeleted)
+	for _, h := range []struct {
+		kind historyKind
+		path string
+		save apiSave
+	}{
+		{songHistory, "/api/v1/songs/:song", saveAPISong},
+		{bookHistory, "/api/v1/books/:book", saveAPIBook},
+	} {
+		r.GET(h.path+"/history", apiHistory(h.kind))
+		r.GET(h.path+"/history/:rev", apiRevision(h.kind))
+		r.POST(h.path+"/history/:rev/restore", apiWritable(RoleEditor, apiRestore(h.kind, h.save)))
+		r.GET(h.path+"/diff", apiDiff(h.kind))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+func apiError(w http.ResponseWriter, status int, message string, diags []Diagnostic) {
+	writeJSON(w, status, apiErrorBody{message, diags})
+}
+
+//apiWritable wraps an API handler that changes songs or songbooks,
+//refusing the request when the server is read-only, or the user does not
+//have the Role.
+func apiWritable(role Role, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if config.ReadOnly {
+			apiError(w, http.StatusForbidden, "this song book is read-only", nil)
+			return
+		}
+
+		if status, message := checkRole(r, role); status != 0 {
+			if status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Basic realm="isb"`)
+			}
+			apiError(w, status, message, nil)
+			return
+		}
+
+		h(w, r, p)
+	}
+}
+
+//apiStoreError responds with the message and status of an error from a
+//fileStore, logging the cause of unexpected errors.
+func apiStoreError(w http.ResponseWriter, err error) {
+	status := storeStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Println(errors.Unwrap(err))
+	}
+
+	apiError(w, status, err.Error(), nil)
+}
+
+//readAPIContent reads the apiContent from the request body.
+func readAPIContent(w http.ResponseWriter, r *http.Request) (apiContent, bool) {
+	var body apiContent
+
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody)).Decode(&body)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid request body: "+err.Error(), nil)
+		return body, false
+	}
+
+	return body, true
+}
+
+//setETag gives the Version of the file in the response's ETag header.
+func setETag(w http.ResponseWriter, version string) {
+	w.Header().Set("ETag", `"`+version+`"`)
+}
+
+//apiSaver returns the function saving a change to a file of <s> made with
+//the request. If the request says which version was changed, with the
+//body's Version or an If-Match header with the ETag, the file is only saved
+//if it still has that version, otherwise the error is ErrChanged.
+func apiSaver(s fileStore, r *http.Request, body apiContent) apiSaveFunc {
+	version := body.Version
+	if match := r.Header.Get("If-Match"); len(version) == 0 && match != "*" {
+		version = strings.Trim(strings.TrimPrefix(match, "W/"), `"`)
+	}
+
+	if len(version) == 0 {
+		return s.Save
+	}
+
+	return func(name string, content []byte, change Change) error {
+		return s.SaveIfUnchanged(name, content, change, name, version)
+	}
+}
+
+func apiListSongs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	q := strings.ToLower(strings.TrimSpace(r.FormValue("q")))
+
+	songs := make([]apiSongSummary, 0)
+	for _, s := range catalog.Songs() {
+		if strings.Contains(strings.ToLower(s.Title), q) {
+			songs = append(songs, apiSongSummary{s.Link, s.Title})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, songs)
+}
+
+func apiSearch(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	limit := searchLimit
+	if l := r.FormValue("limit"); len(l) > 0 {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			apiError(w, http.StatusBadRequest, "limit must be a positive number", nil)
+			return
+		}
+		limit = n
+	}
+
+	writeJSON(w, http.StatusOK, catalog.Search(r.FormValue("q"), limit))
+}
+
+func apiGetSong(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	song, err := catalog.Song(p.ByName("song"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+
+	if version, err := songStore.Version(song.Link()); err == nil {
+		setETag(w, version)
+	}
+
+	applyRequestOptions(song, r)
+	writeJSON(w, http.StatusOK, newAPISong(song))
+}
+
+func newAPISong(song *Song) apiSong {
+	s := apiSong{
+		Link:           song.Link(),
+		Title:          song.Title,
+		Section:        song.Section,
+		Subtitles:      song.Subtitles,
+		Artists:        song.Artists,
+		Composers:      song.Composers,
+		Lyricists:      song.Lyricists,
+		Copyright:      song.Copyright,
+		Key:            song.DisplayKey().Name(song.GetNaming()),
+		OriginalKey:    song.Key.Name(song.GetNaming()),
+		Transpose:      song.GetTranspose(),
+		Capo:           song.GetCapo(),
+		BeforeComments: newAPIComments(song.BeforeComments),
+		AfterComments:  newAPIComments(song.AfterComments),
+		Stanzas:        make([]apiStanza, len(song.Stanzas)),
+	}
+
+	for i, stanza := range song.Stanzas {
+		kind := stanza.sectionName()
+		if len(kind) == 0 {
+			kind = "verse"
+		}
+
+		st := apiStanza{
+			Number:         stanza.Number,
+			Kind:           kind,
+			Label:          stanza.GetLabel(),
+			Recall:         stanza.Recall,
+			ShowNumber:     stanza.IsNumbered(),
+			BeforeComments: newAPIComments(stanza.BeforeComments),
+			AfterComments:  newAPIComments(stanza.AfterComments),
+			Lines:          make([]apiLine, len(stanza.Lines)),
+		}
+
+		for j, line := range stanza.Lines {
+			l := apiLine{Text: line.Text, EchoIndex: line.EchoIndex, Chords: make([]apiChord, len(line.Chords))}
+			if !line.HasEcho() {
+				l.EchoIndex = -1
+			}
+
+			for k, chord := range line.Chords {
+				l.Chords[k] = apiChord{chord.GetText(), chord.ConcertText(), chord.Position}
+			}
+			st.Lines[j] = l
+		}
+
+		s.Stanzas[i] = st
+	}
+
+	return s
+}
+
+func newAPIComments(comments []Comment) []apiComment {
+	result := make([]apiComment, len(comments))
+	for i, c := range comments {
+		style := "normal"
+		switch c.Style {
+		case CommentItalic:
+			style = "italic"
+		case CommentBox:
+			style = "box"
+		}
+
+		result[i] = apiComment{c.Text, style}
+	}
+
+	return result
+}
+
+//checkSong returns the problems in the song content, and whether it can be
+//saved, i.e. has no errors.
+func checkSong(name string, content string) ([]Diagnostic, bool) {
+	_, diags, err := ParseSong(strings.NewReader(content), ParseOptions{Filename: name + ".song"})
+	if err != nil {
+		diags = append(diags, Diagnostic{File: name + ".song", Severity: SeverityError, Message: err.Error()})
+	}
+
+	return diags, !HasErrors(diags)
+}
+
+func apiCreateSong(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	body, ok := readAPIContent(w, r)
+	if !ok {
+		return
+	}
+
+	//check the name before the content, the file is created when saved
+	if _, err := songStore.Path(body.Name); err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	saveAPISong(w, body.Name, body.Content, http.StatusCreated, songStore.SaveNew, requestChange(r, body.Message, "Created"))
+}
+
+func apiUpdateSong(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	body, ok := readAPIContent(w, r)
+	if !ok {
+		return
+	}
+
+	name := p.ByName("song")
+	if _, err := catalog.Song(name); err != nil {
+		apiError(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+
+	saveAPISong(w, name, body.Content, http.StatusOK, apiSaver(songStore, r, body), requestChange(r, body.Message, "Edited"))
+}
+
+//saveAPISong checks and writes the song, responding with the saved song.
+func saveAPISong(w http.ResponseWriter, name string, content string, status int, save apiSaveFunc, change Change) {
+	diags, ok := checkSong(name, content)
+	if !ok {
+		apiError(w, http.StatusUnprocessableEntity, "the song has errors", diags)
+		return
+	}
+
+	err := save(name, []byte(content), change)
+	if errors.Is(err, ErrChanged) {
+		writeEditConflict(w, songStore, name, []byte(content), err)
+		return
+	}
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	setETag(w, contentVersion([]byte(content)))
+
+	if err := catalog.ReloadSongs(name); err != nil {
+		log.Println(err)
+	}
+
+	song, err := catalog.Song(name)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/songs/"+name)
+	writeJSON(w, status, newAPISong(song))
+}
+
+func apiDeleteSong(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	name := p.ByName("song")
+	if _, err := catalog.Song(name); err != nil {
+		apiError(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+
+	if err := songStore.Delete(name, requestChange(r, r.FormValue("message"), "Deleted")); err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	if err := catalog.ReloadSongs(name); err != nil {
+		log.Println(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//apiRenderSong renders the song as a PDF ("format=pdf", the default) or
+//as .song text ("format=chordpro"), with the requested options.
+func apiRenderSong(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	song, err := catalog.Song(p.ByName("song"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+
+	applyRequestOptions(song, r)
+
+	var buf *bytes.Buffer
+	contentType := "application/pdf"
+
+	switch r.FormValue("format") {
+	case "", "pdf":
+		buf, err = WriteSongPDF(song)
+	case "chordpro":
+		buf, err = WriteSongText(song)
+		contentType = "text/plain; charset=utf-8"
+	default:
+		apiError(w, http.StatusBadRequest, fmt.Sprintf("unknown format %q, expected pdf or chordpro", r.FormValue("format")), nil)
+		return
+	}
+
+	writeRendered(w, contentType, buf, err)
+}
+
+func writeRendered(w http.ResponseWriter, contentType string, buf *bytes.Buffer, err error) {
+	if err != nil {
+		log.Println(err)
+		apiError(w, http.StatusInternalServerError, "could not render: "+err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Println(err)
+	}
+}
+
+func apiListBooks(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	books := make([]apiSongSummary, 0)
+	for _, b := range catalog.Books() {
+		books = append(books, apiSongSummary{strings.TrimSuffix(b.Link, "/index"), b.Title})
+	}
+
+	writeJSON(w, http.StatusOK, books)
+}
+
+func apiGetBook(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sbook, err := catalog.Book(p.ByName("book"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+
+	if version, err := bookStore.Version(sbook.Link()); err == nil {
+		setETag(w, version)
+	}
+
+	writeJSON(w, http.StatusOK, newAPIBook(sbook))
+}
+
+func newAPIBook(sbook *Songbook) apiBook {
+	b := apiBook{
+		Link:          sbook.Link(),
+		Title:         sbook.Title,
+		FixedOrder:    sbook.FixedOrder,
+		UseSection:    sbook.UseSection,
+		IndexChorus:   sbook.IndexChorus,
+		IndexPosition: "none",
+		Naming:        sbook.Naming.String(),
+		Songs:         make([]apiBookSong, 0, len(sbook.Songs)),
+	}
+
+	switch sbook.IndexPosition {
+	case IndexStart:
+		b.IndexPosition = "start"
+	case IndexEnd:
+		b.IndexPosition = "end"
+	}
+
+	for _, song := range GetSongSlice(sbook) {
+		b.Songs = append(b.Songs, apiBookSong{song.SongNumber, song.Link(), song.Title, song.DisplayKey().String()})
+	}
+
+	return b
+}
+
+//checkBook returns the problems in the songlist content, i.e. songs that do
+//not exist, and whether it can be saved.
+func checkBook(name string, content string) ([]Diagnostic, bool) {
+	diags := make([]Diagnostic, 0)
+
+	_, err := parseSongbook(strings.NewReader(content), name+".songlist", func(file string) (*Song, error) {
+		song, err := catalog.Song(strings.TrimSuffix(file, ".song"))
+		if err != nil {
+			diags = append(diags, Diagnostic{File: name + ".songlist", Severity: SeverityError, Message: err.Error()})
+		}
+		return song, err
+	})
+	if err != nil {
+		diags = append(diags, Diagnostic{File: name + ".songlist", Severity: SeverityError, Message: err.Error()})
+	}
+
+	return diags, !HasErrors(diags)
+}
+
+func apiCreateBook(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	body, ok := readAPIContent(w, r)
+	if !ok {
+		return
+	}
+
+	//check the name before the content, the file is created when saved
+	if _, err := bookStore.Path(body.Name); err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	saveAPIBook(w, body.Name, body.Content, http.StatusCreated, bookStore.SaveNew, requestChange(r, body.Message, "Created"))
+}
+
+func apiUpdateBook(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	body, ok := readAPIContent(w, r)
+	if !ok {
+		return
+	}
+
+	name := p.ByName("book")
+	if _, err := catalog.Book(name); err != nil {
+		apiError(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+
+	saveAPIBook(w, name, body.Content, http.StatusOK, apiSaver(bookStore, r, body), requestChange(r, body.Message, "Edited"))
+}
+
+//saveAPIBook checks and writes the songlist, responding with the saved songbook.
+func saveAPIBook(w http.ResponseWriter, name string, content string, status int, save apiSaveFunc, change Change) {
+	diags, ok := checkBook(name, content)
+	if !ok {
+		apiError(w, http.StatusUnprocessableEntity, "the songbook has errors", diags)
+		return
+	}
+
+	err := save(name, []byte(content), change)
+	if errors.Is(err, ErrChanged) {
+		writeEditConflict(w, bookStore, name, []byte(content), err)
+		return
+	}
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	setETag(w, contentVersion([]byte(content)))
+
+	if err := catalog.ReloadBooks(name); err != nil {
+		log.Println(err)
+	}
+
+	sbook, err := catalog.Book(name)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/books/"+name)
+	writeJSON(w, status, newAPIBook(sbook))
+}
+
+func apiDeleteBook(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	name := p.ByName("book")
+	if _, err := catalog.Book(name); err != nil {
+		apiError(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+
+	if err := bookStore.Delete(name, requestChange(r, r.FormValue("message"), "Deleted")); err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	if err := catalog.ReloadBooks(name); err != nil {
+		log.Println(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//apiRenderBook renders the songbook as a PDF, "version" is print (the
+//default) or electronic.
+func apiRenderBook(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sbook, err := catalog.Book(p.ByName("book"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+
+	for i, song := range sbook.Songs {
+		applyBookOptions(&song, r.FormValue)
+		sbook.Songs[i] = song
+	}
+
+	var buf *bytes.Buffer
+	switch r.FormValue("version") {
+	case "", "print":
+		buf, err = WriteBookPDF(sbook)
+	case "electronic":
+		buf, err = WriteBookPDFElectronic(sbook)
+	default:
+		apiError(w, http.StatusBadRequest, fmt.Sprintf("unknown version %q, expected print or electronic", r.FormValue("version")), nil)
+		return
+	}
+
+	writeRendered(w, "application/pdf", buf, err)
+}
+
+//apiSave checks and writes a song or songbook with the apiSaveFunc,
+//responding with it.
+type apiSave func(w http.ResponseWriter, name string, content string, status int, save apiSaveFunc, change Change)
+
+//apiSaveFunc saves the content of a song or songbook, e.g. fileStore.Save.
+type apiSaveFunc func(name string, content []byte, change Change) error
+
+//apiDiffBody is how a song or songbook changed between two revisions.
+type apiDiffBody struct {
+	From  int        `json:"from"`
+	To    int        `json:"to"`
+	Lines []DiffLine `json:"lines"`
+}
+
+//apiNoHistory responds with an error if no history is kept.
+func apiNoHistory(w http.ResponseWriter) bool {
+	if history == nil {
+		apiError(w, http.StatusNotFound, "no history is kept", nil)
+		return true
+	}
+
+	return false
+}
+
+//apiDeleted lists the deleted songs and songbooks, which can be restored
+//from their history.
+func apiDeleted(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if apiNoHistory(w) {
+		return
+	}
+
+	deleted := make(map[string][]string)
+	for _, k := range []historyKind{songHistory, bookHistory} {
+		names, err := history.Deleted(k.Store)
+		if err != nil {
+			log.Println(err)
+			apiError(w, http.StatusInternalServerError, "the history could not be read", nil)
+			return
+		}
+		deleted[k.Param+"s"] = names
+	}
+
+	writeJSON(w, http.StatusOK, deleted)
+}
+
+//apiHistory returns a handler listing the revisions of a song or songbook,
+//oldest first, without their content.
+func apiHistory(k historyKind) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if apiNoHistory(w) {
+			return
+		}
+
+		revs, err := history.Revisions(k.Store, p.ByName(k.Param))
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+
+		for i := range revs {
+			revs[i].Content = ""
+		}
+
+		writeJSON(w, http.StatusOK, revs)
+	}
+}
+
+func apiRevision(k historyKind) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		rev, err := findRevision(k.Store, p.ByName(k.Param), p.ByName("rev"))
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, rev)
+	}
+}
+
+//apiDiff returns a handler comparing two revisions of a song or songbook,
+//"to" (by default the latest) and "from" (by default the one before it).
+func apiDiff(k historyKind) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if apiNoHistory(w) {
+			return
+		}
+
+		name := p.ByName(k.Param)
+		to := r.FormValue("to")
+		if len(to) == 0 {
+			revs, err := history.Revisions(k.Store, name)
+			if err != nil {
+				apiStoreError(w, err)
+				return
+			}
+			to = strconv.Itoa(len(revs))
+		}
+
+		rev, from, diff, err := revisionDiff(k.Store, name, to, r.FormValue("from"))
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, apiDiffBody{from, rev.Number, diff})
+	}
+}
+
+//apiRestore returns a handler saving a revision of a song or songbook as
+//its current version, bringing it back if it was deleted.
+func apiRestore(k historyKind, save apiSave) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		name := p.ByName(k.Param)
+		rev, err := restorableRevision(k.Store, name, p.ByName("rev"))
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+
+		save(w, name, rev.Content, http.StatusOK, apiSaver(k.Store, r, apiContent{}),
+			requestChange(r, r.FormValue("message"), fmt.Sprintf("Restored revision %d", rev.Number)))
+	}
+}
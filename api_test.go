@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+//newTestAPI serves the API for a test Catalog, returning a function that
+//restores the previous catalog and directories.
+func newTestAPI(t *testing.T) (http.Handler, func()) {
+	c, dir := newTestCatalog(t,
+		map[string]string{"a.song": "{title: Alpha}\n{key: G}\n[G]Amazing [D]grace\n", "b.song": "{title: Beta}\nLine\n"},
+		map[string]string{"book.songlist": "{title: The Book}\na {key: A}\nb\n"})
+
+	oldCatalog, oldSongs, oldBooks := catalog, songs_root, books_root
+	catalog, songs_root, books_root = c, filepath.Join(dir, "songs"), filepath.Join(dir, "books")
+
+	r := httprouter.New()
+	registerAPI(r)
+
+	return r, func() {
+		catalog, songs_root, books_root = oldCatalog, oldSongs, oldBooks
+		os.RemoveAll(dir)
+	}
+}
+
+var apiTests = []struct {
+	method   string
+	url      string
+	body     string
+	status   int
+	expected string
+}{
+	{"GET", "/api/v1/songs", "", 200, `[{"link":"a","title":"Alpha"},{"link":"b","title":"Beta"}]`},
+	{"GET", "/api/v1/songs?q=BET", "", 200, `[{"link":"b","title":"Beta"}]`},
+	{"GET", "/api/v1/songs/a?transpose=2", "", 200, `"key":"A","original_key":"G","transpose":2`},
+	{"GET", "/api/v1/songs/a?capo=2", "", 200, `"chords":[{"text":"F","concert":"G","position":0},{"text":"C","concert":"D","position":8}]`},
+	{"GET", "/api/v1/search?q=grac", "", 200, `[{"link":"a","title":"Alpha","first_line":"Amazing grace","match":"Amazing grace","score":`},
+	{"GET", "/api/v1/search?q=nothing", "", 200, `[]`},
+	{"GET", "/api/v1/search?q=line&limit=none", "", 400, `limit must be a positive number`},
+	{"GET", "/api/v1/songs/missing", "", 404, `{"error":"song \"missing\" not found"}`},
+	{"POST", "/api/v1/songs", `{"name":"c","content":"{title: Gamma}\nLine\n"}`, 201, `"link":"c","title":"Gamma"`},
+	{"POST", "/api/v1/songs", `{"name":"c","content":"{title: Gamma}\n"}`, 409, `already exists`},
+	{"POST", "/api/v1/songs", `{"name":"../c","content":"x"}`, 400, `invalid song name`},
+	{"POST", "/api/v1/songs", `{"name":"d","content":"A {echo: b\n"}`, 422, `"diagnostics":[{"file":"d.song","line":1`},
+	{"POST", "/api/v1/songs", `not json`, 400, `invalid request body`},
+	{"PUT", "/api/v1/songs/c", `{"content":"{title: Gamma 2}\nLine\n"}`, 200, `"title":"Gamma 2"`},
+	{"PUT", "/api/v1/songs/d", `{"content":"x"}`, 404, `not found`},
+	{"GET", "/api/v1/songs/c/render?format=chordpro&transpose=1", "", 200, "{title: Gamma 2}\n\nLine\n"},
+	{"GET", "/api/v1/songs/c/render?format=html", "", 400, `unknown format`},
+	{"DELETE", "/api/v1/songs/c", "", 204, ""},
+	{"GET", "/api/v1/songs/c", "", 404, `not found`},
+	{"GET", "/api/v1/books", "", 200, `[{"link":"book","title":"The Book"}]`},
+	{"GET", "/api/v1/books/book", "", 200, `"songs":[{"number":1,"link":"a","title":"Alpha","key":"A"},{"number":2,"link":"b","title":"Beta"}]`},
+	{"POST", "/api/v1/books", `{"name":"new","content":"a\nmissing\n"}`, 422, `song \"missing\" not found`},
+	{"POST", "/api/v1/books", `{"name":"new","content":"{title: New}\nb\n"}`, 201, `"title":"New"`},
+	{"PUT", "/api/v1/books/new", `{"content":"{title: Newer}\nb\n"}`, 200, `"title":"Newer"`},
+	{"GET", "/api/v1/books/new/render?version=draft", "", 400, `unknown version`},
+	{"DELETE", "/api/v1/books/new", "", 204, ""},
+	{"GET", "/api/v1/books/new", "", 404, `not found`},
+}
+
+func TestAPI(t *testing.T) {
+	h, done := newTestAPI(t)
+	defer done()
+
+	for _, at := range apiTests {
+		req := httptest.NewRequest(at.method, at.url, strings.NewReader(at.body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != at.status {
+			t.Errorf("%s %s: expected status %d, actual %d (%s)", at.method, at.url, at.status, rec.Code, rec.Body)
+		}
+
+		if !strings.Contains(rec.Body.String(), at.expected) {
+			t.Errorf("%s %s: expected %s in %s", at.method, at.url, at.expected, rec.Body)
+		}
+	}
+}
+
+func TestAPIVersion(t *testing.T) {
+	h, done := newTestAPI(t)
+	defer done()
+
+	do := func(method string, url string, body string, header string, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if len(header) > 0 {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/api/v1/songs/a", "/api/v1/books/book"} {
+		rec := do("GET", path, "", "", "")
+		etag := rec.Header().Get("ETag")
+		if rec.Code != http.StatusOK || len(etag) < 3 {
+			t.Fatalf("GET %s: expected an ETag, actual %d %q", path, rec.Code, etag)
+		}
+
+		content := `{"content":"{title: Changed}\na\n"}`
+
+		//the first change is made to the version read, the second is not
+		rec = do("PUT", path, content, "If-Match", etag)
+		if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+			t.Errorf("PUT %s: expected the change and a new ETag, actual %d %q (%s)", path, rec.Code, rec.Header().Get("ETag"), rec.Body)
+		}
+		newTag := rec.Header().Get("ETag")
+
+		rec = do("PUT", path, content, "If-Match", etag)
+		var conflict editConflict
+		json.NewDecoder(rec.Body).Decode(&conflict)
+		if rec.Code != http.StatusConflict || `"`+conflict.Version+`"` != newTag || !strings.Contains(conflict.Content, "Changed") {
+			t.Errorf("PUT %s of an old version: expected a conflict with the current version, actual %d %+v", path, rec.Code, conflict)
+		}
+
+		old := strings.Trim(etag, `"`)
+		body := content[0:len(content)-1] + `,"version":"` + old + `"}`
+		if rec := do("PUT", path, body, "", ""); rec.Code != http.StatusConflict {
+			t.Errorf("PUT %s with an old version in the body: expected a conflict, actual %d (%s)", path, rec.Code, rec.Body)
+		}
+
+		//without a version, the change is saved whatever the file's version
+		if rec := do("PUT", path, content, "", ""); rec.Code != http.StatusOK {
+			t.Errorf("PUT %s without a version: expected the change, actual %d (%s)", path, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestAPIReadOnly(t *testing.T) {
+	h, done := newTestAPI(t)
+	defer done()
+
+	config.ReadOnly = true
+	defer func() { config.ReadOnly = false }()
+
+	req := httptest.NewRequest("DELETE", "/api/v1/songs/a", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var body apiErrorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusForbidden {
+		t.Errorf("expected a 403 error, actual %d %s", rec.Code, rec.Body)
+	}
+
+	if _, err := catalog.Song("a"); err != nil {
+		t.Errorf("expected the song to still exist: %v", err)
+	}
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+)
+
+//Role is what a user may do. Each Role may do everything the lower Roles may.
+type Role int
+
+const (
+	//RoleViewer may only read songs and songbooks, as anyone can without logging in
+	RoleViewer Role = 0
+	//RoleEditor may also create and change songs and songbooks
+	RoleEditor Role = 1
+	//RoleAdmin may also delete songs and songbooks
+	RoleAdmin Role = 2
+)
+
+var roleNames = []string{"viewer", "editor", "admin"}
+
+func (role Role) String() string {
+	if role < 0 || int(role) >= len(roleNames) {
+		return fmt.Sprintf("Role(%d)", int(role))
+	}
+
+	return roleNames[role]
+}
+
+//ParseRole returns the Role with the given name, e.g. "editor".
+func ParseRole(name string) (Role, error) {
+	for i, n := range roleNames {
+		if strings.EqualFold(name, n) {
+			return Role(i), nil
+		}
+	}
+
+	return RoleViewer, fmt.Errorf("unknown role %q, expected viewer, editor or admin", name)
+}
+
+//User is someone who can log in, read from the users file.
+type User struct {
+	Name string
+	Role Role
+	Hash []byte
+}
+
+//readUsers reads the users file, which has a line "name:role:bcrypt hash"
+//for each user. Blank lines and lines starting with "#" are ignored.
+func readUsers(r io.Reader, filename string) (map[string]User, error) {
+	users := make(map[string]User)
+
+	scanner := bufio.NewScanner(r)
+	num := 0
+	for scanner.Scan() {
+		num++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 || len(fields[0]) == 0 {
+			return nil, fmt.Errorf("%s:%d: expected name:role:hash", filename, num)
+		}
+
+		role, err := ParseRole(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", filename, num, err)
+		}
+
+		if _, err := bcrypt.Cost([]byte(fields[2])); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid password hash: %s", filename, num, err)
+		}
+
+		users[fields[0]] = User{fields[0], role, []byte(fields[2])}
+	}
+
+	return users, scanner.Err()
+}
+
+//writeUsers writes the users in the format read by readUsers.
+func writeUsers(w io.Writer, users map[string]User) error {
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "# isb users: name:role:bcrypt hash, see \"isb user -h\"")
+	for _, name := range names {
+		u := users[name]
+		if _, err := fmt.Fprintf(w, "%s:%s:%s\n", u.Name, u.Role, u.Hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//loadUsersFile reads the users from the named file.
+func loadUsersFile(filename string) (map[string]User, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readUsers(file, filename)
+}
+
+//Session is a logged in user. Changes made in a Session must carry its
+//CSRFToken, so other web sites cannot make them on the user's behalf.
+type Session struct {
+	User      string
+	Role      Role
+	CSRFToken string
+	Expires   time.Time
+
+	//basic is true when the user gave their password with the request
+	//(HTTP basic authentication, e.g. from an API client). A browser that
+	//remembers the password sends it with forms from other web sites too,
+	//so it is only trusted without a CSRFToken for a scriptRequest
+	basic bool
+}
+
+//sessionCookie is the name of the cookie holding the session token.
+const sessionCookie = "isb_session"
+
+//sessionLifetime is how long a user stays logged in.
+const sessionLifetime = 7 * 24 * time.Hour
+
+//Auth holds the users who can log in, and their Sessions.
+//It is safe for concurrent use.
+type Auth struct {
+	users map[string]User
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+//auth is the Auth of the web site, set by runServe when there is a users
+//file. Without one, anyone can do anything (unless the site is read-only).
+var auth *Auth
+
+//NewAuth returns an Auth for the given users, with no Sessions.
+func NewAuth(users map[string]User) *Auth {
+	return &Auth{users: users, sessions: make(map[string]*Session)}
+}
+
+//dummyHash is compared with the password of unknown users, so that logging
+//in takes as long whether or not the user exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("isb"), bcrypt.MinCost)
+
+//check returns the user with the given name and password, or false if the
+//name or password is wrong.
+func (a *Auth) check(name string, password string) (User, bool) {
+	user, ok := a.users[name]
+	hash := user.Hash
+	if !ok {
+		hash = dummyHash
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !ok {
+		return User{}, false
+	}
+
+	return user, true
+}
+
+//Login starts a Session for the user with the given name and password,
+//returning its token, or an error if the name or password is wrong.
+func (a *Auth) Login(name string, password string) (string, *Session, error) {
+	user, ok := a.check(name, password)
+	if !ok {
+		return "", nil, fmt.Errorf("wrong user name or password")
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+	csrf, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	s := &Session{User: user.Name, Role: user.Role, CSRFToken: csrf, Expires: time.Now().Add(sessionLifetime)}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	//forget expired sessions
+	for t, old := range a.sessions {
+		if time.Now().After(old.Expires) {
+			delete(a.sessions, t)
+		}
+	}
+	a.sessions[token] = s
+
+	return token, s, nil
+}
+
+//Logout ends the Session with the given token.
+func (a *Auth) Logout(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.sessions, token)
+}
+
+//Session returns the Session of the request, from its session cookie or
+//HTTP basic authentication, or nil if the user has not logged in.
+//The session cookie comes first, so a user logged in to the web site can
+//change songs there even if their browser also remembers their password.
+func (a *Auth) Session(r *http.Request) *Session {
+	if s := a.cookieSession(r); s != nil {
+		return s
+	}
+
+	if name, password, ok := r.BasicAuth(); ok {
+		if user, ok := a.check(name, password); ok {
+			return &Session{User: user.Name, Role: user.Role, basic: true}
+		}
+	}
+
+	return nil
+}
+
+//cookieSession returns the Session of the request's session cookie, or nil
+//if it has none (or it has expired).
+func (a *Auth) cookieSession(r *http.Request) *Session {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.sessions[cookie.Value]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(s.Expires) {
+		delete(a.sessions, cookie.Value)
+		return nil
+	}
+
+	return s
+}
+
+//newToken returns a random token for a session or CSRF token.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//checkRole returns the HTTP status and message refusing the request if the
+//user does not have the Role, or the request changes something without the
+//Session's CSRF token. The status is 0 if the request is allowed.
+func checkRole(r *http.Request, role Role) (int, string) {
+	if auth == nil {
+		return 0, ""
+	}
+
+	s := auth.Session(r)
+	if s == nil {
+		if role == RoleViewer {
+			return 0, ""
+		}
+		return http.StatusUnauthorized, "You must log in to do this."
+	}
+
+	if s.Role < role {
+		return http.StatusForbidden, fmt.Sprintf("You must be an %s to do this.", role)
+	}
+
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return 0, ""
+	}
+
+	if s.basic {
+		if scriptRequest(r) {
+			return 0, ""
+		}
+		return http.StatusForbidden, "Changes made with a password must be sent to the API with a JSON Content-Type or an X-Requested-With header."
+	}
+
+	token := r.Header.Get("X-CSRF-Token")
+	if len(token) == 0 {
+		token = r.PostFormValue("csrf_token")
+	}
+
+	if len(s.CSRFToken) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) != 1 {
+		return http.StatusForbidden, "The page has expired, reload it and try again."
+	}
+
+	return 0, ""
+}
+
+//scriptRequest returns true if the request is to the API, and is not one a
+//form on another web site could send: it has a JSON body, or an
+//X-Requested-With header, which a browser only sends to another site with
+//that site's permission (which this server never gives).
+func scriptRequest(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		return false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json" || len(r.Header.Get("X-Requested-With")) > 0
+}
+
+//requireRole wraps a handler that only users with the Role may use.
+//A page asked for by someone not logged in redirects to the login page.
+func requireRole(role Role, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		status, message := checkRole(r, role)
+		if status == http.StatusUnauthorized && r.Method == "GET" {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		if status != 0 {
+			http.Error(w, message, status)
+			return
+		}
+
+		h(w, r, p)
+	}
+}
+
+//LoginPage is the data for the login page.
+type LoginPage struct {
+	Next string
+	IndexPage
+}
+
+//registerAuth adds the login and logout pages.
+func registerAuth(r *httprouter.Router) {
+	r.GET("/login", loginHandler)
+	r.POST("/login", loginPostHandler)
+	r.POST("/logout", requireRole(RoleViewer, logoutHandler))
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	showLogin(w, r, http.StatusOK, "")
+}
+
+func showLogin(w http.ResponseWriter, r *http.Request, status int, message string) {
+	t, err := template.ParseFiles(templateFiles(
+		"index.tmpl",
+		"login.tmpl")...)
+	if err != nil {
+		panic(err)
+	}
+
+	data := LoginPage{Next: safeNext(r.FormValue("next")), IndexPage: getBasicIndexData(r)}
+	data.Title = "Log In"
+	if len(message) > 0 {
+		data.Error = message
+	}
+
+	w.WriteHeader(status)
+	if err := t.ExecuteTemplate(w, "login.tmpl", data); err != nil {
+		log.Println(err)
+	}
+}
+
+func loginPostHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	token, s, err := auth.Login(r.PostFormValue("user"), r.PostFormValue("password"))
+	if err != nil {
+		log.Printf("Failed login for %q from %s", r.PostFormValue("user"), r.RemoteAddr)
+		showLogin(w, r, http.StatusUnauthorized, "Wrong user name or password.")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  s.Expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, safeNext(r.PostFormValue("next")), http.StatusSeeOther)
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		auth.Logout(cookie.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+//safeNext returns the page to go to after logging in, which must be on
+//this web site.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+
+	return next
+}
+
+//readPassword reads a line from standard input, without showing it as it
+//is typed when standard input is a terminal.
+func readPassword() (string, error) {
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(b), err
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(password, "\r\n"), err
+}
+
+//runUser runs the "user" command, which adds a user to the users file,
+//changes their password or role, or removes them.
+func runUser(args []string) int {
+	flags := flag.NewFlagSet("user", flag.ExitOnError)
+	roleName := flags.String("role", "editor", "give the user the `role` viewer, editor or admin")
+	remove := flags.Bool("delete", false, "remove the user")
+	cf := addConfigFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: isb user [-role viewer|editor|admin] [-delete] <name>")
+		fmt.Fprintln(os.Stderr, "The password is read from standard input, without showing it on a terminal.")
+		flags.PrintDefaults()
+	}
+
+	names := parseFlags(flags, args)
+	if len(names) != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	if err := cf.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	name := names[0]
+	if len(name) == 0 || strings.ContainsAny(name, ": \t\r\n") {
+		fmt.Fprintf(os.Stderr, "invalid user name %q\n", name)
+		return 2
+	}
+
+	role, err := ParseRole(*roleName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if len(config.UsersFile) == 0 {
+		fmt.Fprintln(os.Stderr, "no users file, set it with -users-file, ISB_USERS_FILE or users_file in the config file")
+		return 2
+	}
+
+	users, err := loadUsersFile(config.UsersFile)
+	if os.IsNotExist(err) {
+		users, err = make(map[string]User), nil
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *remove {
+		if _, ok := users[name]; !ok {
+			fmt.Fprintf(os.Stderr, "no user %q\n", name)
+			return 1
+		}
+		delete(users, name)
+	} else {
+		password, err := readPassword()
+		if len(password) == 0 {
+			if err == nil {
+				err = fmt.Errorf("the password cannot be empty")
+			}
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		users[name] = User{name, role, hash}
+	}
+
+	var b bytes.Buffer
+	writeUsers(&b, users)
+
+	if err := writeFileAtomic(config.UsersFile, b.Bytes(), 0600, true); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var readUsersTests = []struct {
+	input string
+	err   string
+}{
+	{"# comment\n\nann:admin:HASH\nbob:Editor:HASH\n", ""},
+	{"ann:owner:HASH\n", "users:1: unknown role \"owner\""},
+	{"ann:admin\n", "users:1: expected name:role:hash"},
+	{":admin:HASH\n", "users:1: expected name:role:hash"},
+	{"ann:admin:secret\n", "users:1: invalid password hash"},
+}
+
+func TestReadUsers(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+
+	for _, rt := range readUsersTests {
+		users, err := readUsers(strings.NewReader(strings.Replace(rt.input, "HASH", string(hash), -1)), "users")
+
+		if len(rt.err) > 0 {
+			if err == nil || !strings.HasPrefix(err.Error(), rt.err) {
+				t.Errorf("%q: expected error %q, actual %v", rt.input, rt.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", rt.input, err)
+			continue
+		}
+
+		if len(users) != 2 || users["ann"].Role != RoleAdmin || users["bob"].Role != RoleEditor {
+			t.Errorf("%q: unexpected users %v", rt.input, users)
+		}
+
+		//the users can be written and read back
+		var b bytes.Buffer
+		writeUsers(&b, users)
+		again, err := readUsers(&b, "users")
+		if err != nil || len(again) != 2 || !bytes.Equal(again["ann"].Hash, hash) {
+			t.Errorf("%q: expected the users to be written, actual %v (%v)", rt.input, again, err)
+		}
+	}
+}
+
+//newTestAuth sets up authentication for a viewer, editor and admin, each
+//with the password "pw", returning a function that turns it off again.
+func newTestAuth(t *testing.T) func() {
+	hash, err := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth = NewAuth(map[string]User{
+		"viewer": {"viewer", RoleViewer, hash},
+		"editor": {"editor", RoleEditor, hash},
+		"admin":  {"admin", RoleAdmin, hash},
+	})
+
+	return func() { auth = nil }
+}
+
+//login logs the user in, returning the session cookie and CSRF token.
+func login(t *testing.T, h http.Handler, user string) (*http.Cookie, string) {
+	form := url.Values{"user": {user}, "password": {"pw"}, "next": {"/song/a"}}
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/song/a" {
+		t.Fatalf("login %s: expected a redirect to /song/a, actual %d %s", user, rec.Code, rec.Header().Get("Location"))
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie || !cookies[0].HttpOnly {
+		t.Fatalf("login %s: expected a session cookie, actual %v", user, cookies)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	s := auth.Session(req)
+	if s == nil || s.User != user {
+		t.Fatalf("login %s: expected a session, actual %v", user, s)
+	}
+
+	return cookies[0], s.CSRFToken
+}
+
+var roleTests = []struct {
+	user   string
+	method string
+	url    string
+	csrf   bool
+	status int
+}{
+	{"", "GET", "/edit", false, http.StatusSeeOther},
+	{"", "POST", "/edit", false, http.StatusUnauthorized},
+	{"viewer", "GET", "/edit", false, http.StatusForbidden},
+	{"editor", "GET", "/edit", false, http.StatusOK},
+	{"editor", "POST", "/edit", false, http.StatusForbidden},
+	{"editor", "POST", "/edit", true, http.StatusOK},
+	{"editor", "DELETE", "/delete", true, http.StatusForbidden},
+	{"admin", "DELETE", "/delete", false, http.StatusForbidden},
+	{"admin", "DELETE", "/delete", true, http.StatusOK},
+}
+
+func TestRequireRole(t *testing.T) {
+	defer newTestAuth(t)()
+
+	ok := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {}
+
+	r := httprouter.New()
+	registerAuth(r)
+	r.GET("/edit", requireRole(RoleEditor, ok))
+	r.POST("/edit", requireRole(RoleEditor, ok))
+	r.POST("/song/:song/edit", requireRole(RoleEditor, ok))
+	r.DELETE("/delete", requireRole(RoleAdmin, ok))
+
+	cookies := make(map[string]*http.Cookie)
+	tokens := make(map[string]string)
+	for _, user := range []string{"viewer", "editor", "admin"} {
+		cookies[user], tokens[user] = login(t, r, user)
+	}
+
+	for _, rt := range roleTests {
+		req := httptest.NewRequest(rt.method, rt.url, nil)
+		if len(rt.user) > 0 {
+			req.AddCookie(cookies[rt.user])
+		}
+		if rt.csrf {
+			req.Header.Set("X-CSRF-Token", tokens[rt.user])
+		}
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != rt.status {
+			t.Errorf("%s %s as %q (csrf %t): expected %d, actual %d", rt.method, rt.url, rt.user, rt.csrf, rt.status, rec.Code)
+		}
+	}
+
+	//a password the browser remembers is sent with forms from other web
+	//sites too, so is not enough to change a song without the CSRF token
+	form := func(user string) *http.Request {
+		req := httptest.NewRequest("POST", "/song/x/edit", strings.NewReader("content=x"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("editor", "pw")
+		if len(user) > 0 {
+			req.AddCookie(cookies[user])
+			req.Header.Set("X-CSRF-Token", tokens[user])
+		}
+		return req
+	}
+	for _, bt := range []struct {
+		user   string
+		status int
+	}{
+		{"", http.StatusForbidden},
+		{"editor", http.StatusOK},
+		{"viewer", http.StatusForbidden},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, form(bt.user))
+		if rec.Code != bt.status {
+			t.Errorf("POST /song/x/edit with a password, logged in as %q: expected %d, actual %d", bt.user, bt.status, rec.Code)
+		}
+	}
+
+	//after logging out, the session no longer works
+	req := httptest.NewRequest("POST", "/logout", strings.NewReader("csrf_token="+tokens["editor"]))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookies["editor"])
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/edit", nil)
+	req.AddCookie(cookies["editor"])
+	if auth.Session(req) != nil {
+		t.Errorf("expected the session to end after logging out")
+	}
+}
+
+func TestAPIAuth(t *testing.T) {
+	h, done := newTestAPI(t)
+	defer done()
+	defer newTestAuth(t)()
+
+	jsonType := "Content-Type: application/json"
+	for _, at := range []struct {
+		user     string
+		password string
+		header   string
+		method   string
+		url      string
+		body     string
+		status   int
+	}{
+		{"", "", "", "GET", "/api/v1/songs/a", "", http.StatusOK},
+		{"", "", jsonType, "PUT", "/api/v1/songs/a", `{"content":"{title: Alpha}\n"}`, http.StatusUnauthorized},
+		{"editor", "wrong", jsonType, "PUT", "/api/v1/songs/a", `{"content":"{title: Alpha}\n"}`, http.StatusUnauthorized},
+		{"viewer", "pw", jsonType, "PUT", "/api/v1/songs/a", `{"content":"{title: Alpha}\n"}`, http.StatusForbidden},
+		{"editor", "pw", jsonType, "PUT", "/api/v1/songs/a", `{"content":"{title: Alpha}\n"}`, http.StatusOK},
+		//a form on another web site could send the password the browser remembers
+		{"editor", "pw", "Content-Type: text/plain", "PUT", "/api/v1/songs/a", `{"content":"{title: Alpha}\n"}`, http.StatusForbidden},
+		{"editor", "pw", "X-Requested-With: XMLHttpRequest", "DELETE", "/api/v1/songs/b", "", http.StatusForbidden},
+		{"admin", "pw", "", "DELETE", "/api/v1/songs/b", "", http.StatusForbidden},
+		{"admin", "pw", "X-Requested-With: XMLHttpRequest", "DELETE", "/api/v1/songs/b", "", http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(at.method, at.url, strings.NewReader(at.body))
+		if len(at.user) > 0 {
+			req.SetBasicAuth(at.user, at.password)
+		}
+		if header := strings.SplitN(at.header, ": ", 2); len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != at.status {
+			t.Errorf("%s %s as %q: expected %d, actual %d (%s)", at.method, at.url, at.user, at.status, rec.Code, rec.Body)
+		}
+		if rec.Code == http.StatusUnauthorized && len(rec.Header().Get("WWW-Authenticate")) == 0 {
+			t.Errorf("%s %s as %q: expected WWW-Authenticate", at.method, at.url, at.user)
+		}
+	}
+}
+
+var safeNextTests = []struct {
+	next     string
+	expected string
+}{
+	{"/song/a/edit", "/song/a/edit"},
+	{"", "/"},
+	{"https://example.com/", "/"},
+	{"//example.com/", "/"},
+	{"/\\example.com/", "/"},
+}
+
+func TestSafeNext(t *testing.T) {
+	for _, st := range safeNextTests {
+		if actual := safeNext(st.next); actual != st.expected {
+			t.Errorf("safeNext(%q): expected %q, actual %q", st.next, st.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Catalog holds every Song and Songbook, read once from the songs and books
+//directories and kept until they are reloaded (e.g. after an edit).
+//It also holds the SearchIndex of the songs, the recently viewed songs and
+//the last error to show.
+//A Catalog is safe for concurrent use; the Songs and Songbooks it returns
+//are copies, so can be changed (e.g. transposed) by the caller.
+type Catalog struct {
+	songsRoot string
+	booksRoot string
+
+	//syncMu is held while the files are read, see Sync
+	syncMu    sync.Mutex
+	songFiles map[string]fileState
+	bookFiles map[string]fileState
+
+	mu        sync.RWMutex
+	songs     map[string]*Song
+	books     map[string]*Songbook
+	songList  []DisplayList
+	bookList  []DisplayList
+	recent    []DisplayList
+	lastError string
+
+	index *SearchIndex
+}
+
+//catalog is the Catalog served by the web site, set by runServe.
+var catalog *Catalog
+
+//NewCatalog returns an empty Catalog of the songs and songbooks in the
+//given directories, call Load to read them.
+func NewCatalog(songsRoot string, booksRoot string) *Catalog {
+	return &Catalog{
+		songsRoot: songsRoot,
+		booksRoot: booksRoot,
+		songs:     make(map[string]*Song),
+		books:     make(map[string]*Songbook),
+		songList:  make([]DisplayList, 0),
+		bookList:  make([]DisplayList, 0),
+		recent:    make([]DisplayList, 0),
+		index:     NewSearchIndex(),
+	}
+}
+
+//Load reads every song and songbook, replacing any already read.
+func (c *Catalog) Load() error {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+
+	//the files are scanned first, so any changed while reading are found by Sync
+	songFiles, bookFiles, err := c.scan()
+	if err != nil {
+		return err
+	}
+
+	songs := make(map[string]*Song)
+
+	files, err := ioutil.ReadDir(c.songsRoot)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(strings.ToLower(f.Name()), ".song") {
+			continue
+		}
+
+		song, err := ParseSongFile(c.songsRoot+"/"+f.Name(), 0)
+		if err != nil {
+			return err
+		}
+		songs[song.Link()] = song
+	}
+
+	index := NewSearchIndex()
+	for link, song := range songs {
+		index.Add(link, song)
+	}
+
+	c.mu.Lock()
+	c.songs = songs
+	c.index = index
+	c.updateSongList()
+	c.mu.Unlock()
+
+	books := make(map[string]*Songbook)
+
+	files, err = ioutil.ReadDir(c.booksRoot)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(strings.ToLower(f.Name()), ".songlist") {
+			continue
+		}
+
+		book, err := c.parseBook(f.Name())
+		if err != nil {
+			return err
+		}
+		books[book.Link()] = book
+	}
+
+	c.mu.Lock()
+	c.books = books
+	c.updateBookList()
+	c.mu.Unlock()
+
+	c.songFiles = songFiles
+	c.bookFiles = bookFiles
+
+	return nil
+}
+
+//parseBook reads the named .songlist file, with its songs from the Catalog.
+func (c *Catalog) parseBook(filename string) (*Songbook, error) {
+	return parseSongbookFile(c.booksRoot+"/"+filename, func(file string) (*Song, error) {
+		link := file[0 : len(file)-len(".song")]
+		if !validName(link) {
+			return nil, &StoreError{"read", "song", link, ErrInvalidName}
+		}
+
+		c.mu.RLock()
+		song, ok := c.songs[link]
+		c.mu.RUnlock()
+
+		if !ok {
+			return ParseSongFile(c.songsRoot+"/"+file, 0)
+		}
+
+		s := song.Copy()
+		return &s, nil
+	})
+}
+
+//Song returns a copy of the Song with the given link (its file name without
+//".song"), or an error if there is no such song.
+func (c *Catalog) Song(link string) (*Song, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	song, ok := c.songs[link]
+	if !ok {
+		return nil, fmt.Errorf("song %q not found", link)
+	}
+
+	s := song.Copy()
+	return &s, nil
+}
+
+//Book returns a copy of the Songbook with the given link (its file name
+//without ".songlist"), or an error if there is no such songbook.
+func (c *Catalog) Book(link string) (*Songbook, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	book, ok := c.books[link]
+	if !ok {
+		return nil, fmt.Errorf("songbook %q not found", link)
+	}
+
+	b := book.Copy()
+	return &b, nil
+}
+
+//Songs returns the title and link of every Song, sorted by title.
+func (c *Catalog) Songs() []DisplayList {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.songList
+}
+
+//Books returns the title and index page link of every Songbook, sorted by title.
+func (c *Catalog) Books() []DisplayList {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.bookList
+}
+
+//Search returns up to <limit> songs matching the query, see SearchIndex.Search.
+func (c *Catalog) Search(query string, limit int) []SearchResult {
+	c.mu.RLock()
+	index := c.index
+	c.mu.RUnlock()
+
+	return index.Search(query, limit)
+}
+
+//LintContext returns every Song and Songbook to compare a song with when
+//checking it, see Lint. They are the Catalog's own, so must not be changed.
+func (c *Catalog) LintContext() LintContext {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ctx := LintContext{
+		Library: make([]LintFile, 0, len(c.songs)),
+		Books:   make([]*Songbook, 0, len(c.books)),
+	}
+	for _, dl := range c.songList {
+		ctx.Library = append(ctx.Library, LintFile{Name: c.songsRoot + "/" + dl.Link + ".song", Song: c.songs[dl.Link]})
+	}
+	for _, dl := range c.bookList {
+		ctx.Books = append(ctx.Books, c.books[strings.TrimSuffix(dl.Link, "/index")])
+	}
+
+	return ctx
+}
+
+//ReloadSongs reads the Songs with the given links from their files again,
+//removing any whose file no longer exists. Songbooks are reloaded too,
+//as they contain the Songs.
+//A Song that cannot be read is left as it was, and the others are still
+//reloaded; the first such error is returned.
+func (c *Catalog) ReloadSongs(links ...string) error {
+	var first error
+	for _, link := range links {
+		song, err := ParseSongFile(c.songsRoot+"/"+link+".song", 0)
+		if err != nil && !os.IsNotExist(err) {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		if song != nil {
+			c.songs[link] = song
+			c.index.Add(link, song)
+		} else {
+			delete(c.songs, link)
+			c.index.Remove(link)
+		}
+		c.updateSongList()
+		c.mu.Unlock()
+	}
+
+	c.mu.RLock()
+	books := make([]string, 0, len(c.books))
+	for l := range c.books {
+		books = append(books, l)
+	}
+	c.mu.RUnlock()
+
+	if err := c.ReloadBooks(books...); err != nil && first == nil {
+		first = err
+	}
+
+	return first
+}
+
+//ReloadBooks reads the Songbooks with the given links from their files
+//again, removing any whose file no longer exists.
+//As with ReloadSongs, a Songbook that cannot be read is left as it was.
+func (c *Catalog) ReloadBooks(links ...string) error {
+	var first error
+	for _, link := range links {
+		book, err := c.parseBook(link + ".songlist")
+		if err != nil && !os.IsNotExist(err) {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		if book != nil {
+			c.books[link] = book
+		} else {
+			delete(c.books, link)
+		}
+		c.updateBookList()
+		c.mu.Unlock()
+	}
+
+	return first
+}
+
+//updateSongList updates songList from the Songs, the caller must hold the
+//lock. The list is replaced rather than changed, as callers may still be
+//using it.
+func (c *Catalog) updateSongList() {
+	titles := make(map[string]string, len(c.songs))
+	for link, song := range c.songs {
+		titles[link] = song.Title
+	}
+
+	c.songList = sortedList(titles)
+}
+
+//updateBookList updates bookList from the Songbooks, the caller must hold the lock.
+func (c *Catalog) updateBookList() {
+	titles := make(map[string]string, len(c.books))
+	for link, book := range c.books {
+		titles[link+"/index"] = book.Title
+	}
+
+	c.bookList = sortedList(titles)
+}
+
+//sortedList returns the given titles (by link) sorted by title, then link.
+func sortedList(titles map[string]string) []DisplayList {
+	links := make([]string, 0, len(titles))
+	for link := range titles {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+
+	list := make([]DisplayList, len(links))
+	for i, link := range links {
+		list[i] = DisplayList{Link: link, Title: titles[link]}
+	}
+	sort.Stable(ByTitle(list))
+
+	return list
+}
+
+//AddRecent adds the song to the top of the recently viewed songs.
+func (c *Catalog) AddRecent(link string, title string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	//if the song is already in the list, move it to the top
+	recent := []DisplayList{DisplayList{Link: link, Title: title}}
+	for _, dl := range c.recent {
+		if dl.Title != title && len(recent) < 5 {
+			recent = append(recent, dl)
+		}
+	}
+
+	c.recent = recent
+}
+
+//Recent returns the recently viewed songs, the most recent first.
+func (c *Catalog) Recent() []DisplayList {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.recent
+}
+
+//SetError sets the error to show on the next page shown.
+func (c *Catalog) SetError(err string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastError = err
+}
+
+//TakeError returns the error to show, clearing it so it is only shown once.
+func (c *Catalog) TakeError() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.lastError
+	c.lastError = ""
+	return err
+}
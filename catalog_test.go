@@ -0,0 +1,169 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+//newTestCatalog returns a Catalog of the given songs and songbooks
+//(file name to contents), written into a temporary directory.
+func newTestCatalog(t *testing.T, songs map[string]string, books map[string]string) (*Catalog, string) {
+	dir, err := ioutil.TempDir("", "isb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for sub, files := range map[string]map[string]string{"songs": songs, "books": books} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, sub, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	c := NewCatalog(filepath.Join(dir, "songs"), filepath.Join(dir, "books"))
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	return c, dir
+}
+
+func TestCatalog(t *testing.T) {
+	c, dir := newTestCatalog(t,
+		map[string]string{"b.song": "{title: Beta}\n{key: G}\n[G]Line\n", "a.song": "{title: Alpha}\nLine\n"},
+		map[string]string{"book.songlist": "{title: The Book}\nb {key: A}\na.song\n"})
+	defer os.RemoveAll(dir)
+
+	expected := []DisplayList{{Link: "a", Title: "Alpha"}, {Link: "b", Title: "Beta"}}
+	if songs := c.Songs(); len(songs) != 2 || songs[0] != expected[0] || songs[1] != expected[1] {
+		t.Errorf("expected songs %v, actual %v", expected, songs)
+	}
+
+	if books := c.Books(); len(books) != 1 || books[0] != (DisplayList{Link: "book/index", Title: "The Book"}) {
+		t.Errorf("unexpected books %v", books)
+	}
+
+	//returned songs are copies, so changing them does not change the catalog
+	song, err := c.Song("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	song.Transpose(2)
+
+	song, _ = c.Song("b")
+	if chord := song.Stanzas[0].Lines[0].Chords[0].GetText(); chord != "G" {
+		t.Errorf("expected catalog song to keep chord G, actual %s", chord)
+	}
+
+	book, err := c.Book("book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chord := book.Songs[1].Stanzas[0].Lines[0].Chords[0].GetText(); chord != "A" {
+		t.Errorf("expected book song in A, actual %s", chord)
+	}
+	if book.Songs[2].Title != "Alpha" {
+		t.Errorf("expected song 2 Alpha, actual %s", book.Songs[2].Title)
+	}
+
+	if _, err := c.Song("missing"); err == nil {
+		t.Errorf("expected an error for a missing song")
+	}
+
+	//reloading picks up changed, new and deleted files
+	ioutil.WriteFile(filepath.Join(dir, "songs", "b.song"), []byte("{title: Gamma}\n{key: G}\n[D]Line\n"), 0644)
+	if err := c.ReloadSongs("b"); err != nil {
+		t.Fatal(err)
+	}
+	book, _ = c.Book("book")
+	if book.Songs[1].Title != "Gamma" {
+		t.Errorf("expected the songbook to have the reloaded song, actual %s", book.Songs[1].Title)
+	}
+
+	os.Remove(filepath.Join(dir, "songs", "a.song"))
+	if err := c.ReloadSongs("a"); err != nil {
+		t.Fatal(err)
+	}
+	expected = []DisplayList{{Link: "b", Title: "Gamma"}}
+	if songs := c.Songs(); len(songs) != 1 || songs[0] != expected[0] {
+		t.Errorf("expected songs %v, actual %v", expected, songs)
+	}
+
+	os.Remove(filepath.Join(dir, "books", "book.songlist"))
+	if err := c.ReloadBooks("book"); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Books()) != 0 {
+		t.Errorf("expected no books, actual %v", c.Books())
+	}
+}
+
+func TestCatalogRecent(t *testing.T) {
+	c := NewCatalog("", "")
+
+	for _, link := range []string{"a", "b", "c", "d", "e", "f", "c"} {
+		c.AddRecent(link, link)
+	}
+
+	expected := []string{"c", "f", "e", "d", "b"}
+	recent := c.Recent()
+	if len(recent) != len(expected) {
+		t.Fatalf("expected %v, actual %v", expected, recent)
+	}
+	for i, r := range recent {
+		if r.Link != expected[i] {
+			t.Errorf("%d: expected %s, actual %s", i, expected[i], r.Link)
+		}
+	}
+
+	c.SetError("oops")
+	if err := c.TakeError(); err != "oops" {
+		t.Errorf("expected error oops, actual %q", err)
+	}
+	if err := c.TakeError(); err != "" {
+		t.Errorf("expected the error to be cleared, actual %q", err)
+	}
+}
+
+func TestCatalogConcurrent(t *testing.T) {
+	c, dir := newTestCatalog(t,
+		map[string]string{"a.song": "{title: Alpha}\n{key: C}\n[C]Line\n"},
+		map[string]string{"book.songlist": "a\n"})
+	defer os.RemoveAll(dir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			song, err := c.Song("a")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			song.Transpose(i)
+			c.AddRecent(song.Link(), song.Title)
+
+			if i%5 == 0 {
+				if err := c.ReloadSongs("a"); err != nil {
+					t.Error(err)
+				}
+			}
+			c.Books()
+		}(i)
+	}
+	wg.Wait()
+
+	song, _ := c.Song("a")
+	if chord := song.Stanzas[0].Lines[0].Chords[0].GetText(); chord != "C" {
+		t.Errorf("expected chord C, actual %s", chord)
+	}
+}
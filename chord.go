@@ -1,75 +1,310 @@
 package main
 
+import (
+	"regexp"
+	"strings"
+)
+
+//Note represents a single note name, i.e. a letter (A-G) and an accidental.
+//Accidental is the number of semitones the letter is raised (sharps) or
+//lowered (flats). The zero Note represents no note at all.
+//Notes always use English letters, see NamingSystem for other names.
+type Note struct {
+	Letter     byte
+	Accidental int
+}
+
 //Chord represents an individual chord in a Song.
-//Including the text displayed, its position on a lyric,
+//Including the text as written, the parsed chord (root note, quality and
+//optional slash-bass note), its position on a lyric,
 //and any key transpositions to apply.
+//Key is the key the Chord is displayed in (i.e. after transposition),
+//it decides whether transposed notes are spelled with sharps or flats.
+//Capo is the fret of the guitarist's capo, if set the Chord is displayed as
+//the shape to play with the capo on, optionally followed by the sounding
+//(concert pitch) chord if ShowConcert is set.
+//Notation chooses between chord names and numbers relative to the Key,
+//and Naming chooses the note names used (by default, as written).
+//Chord text that is not a single chord (e.g. "G-C-G" or "N.C.") has no Root,
+//such chords are rendered by transposing each recognised chord in the text.
 type Chord struct {
-	text      string
-	Position  int
-	Transpose int
+	text        string
+	naming      NamingSystem
+	Root        Note
+	Quality     string
+	Bass        Note
+	Position    int
+	Transpose   int
+	Key         Key
+	Capo        int
+	ShowConcert bool
+	Notation    Notation
+	Naming      NamingSystem
+}
+
+//chordName is a single parsed chord, along with the NamingSystem it
+//was written in.
+type chordName struct {
+	root    Note
+	quality string
+	bass    Note
+	naming  NamingSystem
+}
+
+//NewChord creates a Chord from the given chord text, parsing the
+//root, quality and bass note where possible.
+//The naming system of the text is detected automatically.
+func NewChord(text string, position int, transpose int) Chord {
+	return newNamedChord(text, position, transpose, NamingDefault)
+}
+
+//newNamedChord creates a Chord from text written in the given NamingSystem,
+//NamingDefault detects the naming system.
+func newNamedChord(text string, position int, transpose int, naming NamingSystem) Chord {
+	chord := Chord{text: text, naming: naming, Position: position, Transpose: transpose}
+
+	if name, ok := parseChordName(text, naming); ok {
+		chord.Root = name.root
+		chord.Quality = name.quality
+		chord.Bass = name.bass
+		chord.naming = name.naming
+	}
+
+	return chord
 }
 
 //GetText returns the text to be displayed for this Chord.
-//This will apply any needed key transposition.
+//This will apply any needed key transposition and capo, or write the
+//Chord as a number if a Notation other than letters is chosen.
 func (chord Chord) GetText() string {
-	return transposeKey(chord.text, chord.Transpose)
+	if chord.Notation != NotationLetters && chord.Key.IsValid() {
+		return chord.NumberText()
+	}
+
+	if chord.Capo == 0 {
+		return chord.ConcertText()
+	}
+
+	shape := chord.render(chord.Transpose-chord.Capo, chord.Key.Transpose(-chord.Capo))
+	if chord.ShowConcert {
+		return shape + " (" + chord.ConcertText() + ")"
+	}
+
+	return shape
 }
 
-var scales = map[string]int{
-	"A":  0,
-	"Bb": 1,
-	"B":  2,
-	"C":  3,
-	"C#": 4,
-	"D":  5,
-	"D#": 6,
-	"E":  7,
-	"F":  8,
-	"F#": 9,
-	"G":  10,
-	"G#": 11,
+//ConcertText returns the text of the sounding Chord, i.e. with any key
+//transposition applied but ignoring the capo.
+func (chord Chord) ConcertText() string {
+	return chord.render(chord.Transpose, chord.Key)
 }
 
-//transposeKey transposes the given <key> by <change> half-notes.
-//Any unknown characters are skipped, as this allows for Chord text such as
-//"G-C-G" to be transposed.
-func transposeKey(key string, change int) string {
-	if change == 0 {
-		return key
+//NumberText returns the Chord written in its Notation, relative to its Key.
+//e.g. an E chord in the key of C is "3" or "III".
+func (chord Chord) NumberText() string {
+	if !chord.Root.IsValid() {
+		return mapChordTokens(chord.text, chord.naming, func(name chordName) string {
+			return renderChordNumber(name.root, name.quality, name.bass, chord.Transpose, chord.Key, chord.Notation)
+		})
 	}
 
-	newKey := ""
+	return renderChordNumber(chord.Root, chord.Quality, chord.Bass, chord.Transpose, chord.Key, chord.Notation)
+}
 
-	for i := 0; i < len(key); i++ {
-		k := key[i:]
+//render returns the Chord text transposed by <change> half-notes and
+//spelled to suit <key>. Untransposed chords are returned as written,
+//unless a different NamingSystem is requested.
+func (chord Chord) render(change int, key Key) string {
+	if !chord.Root.IsValid() {
+		return transposeKey(chord.text, change, key, chord.naming, chord.Naming)
+	}
 
-		//check 2 characters first,
-		//In case there are sharps or flats
-		if len(k) > 1 {
-			k = key[i : i+2]
-		}
+	if change%12 == 0 && (chord.Naming == NamingDefault || chord.Naming == chord.naming) {
+		return chord.text
+	}
 
-		scaleInd, ok := scales[k]
-		if !ok && len(k) > 1 {
-			k = k[0:1]
-			scaleInd, ok = scales[k]
-		}
+	name := chordName{chord.Root, chord.Quality, chord.Bass, chord.naming}
+	return name.render(change, key, chord.Naming)
+}
 
-		if !ok {
-			newKey += k
-		} else {
-			scaleInd = (scaleInd + change) % (len(scales))
-			for k := range scales {
-				if scales[k] == scaleInd {
-					newKey += k
-				}
+//playedName returns the Chord as it is played, i.e. after any key
+//transposition and capo. ok is false if the Chord text is not a single chord.
+func (chord Chord) playedName() (name chordName, ok bool) {
+	if !chord.Root.IsValid() {
+		return chordName{}, false
+	}
+
+	change := chord.Transpose - chord.Capo
+	key := chord.Key.Transpose(-chord.Capo)
+
+	return chordName{
+		root:    chord.Root.transpose(change, key),
+		quality: chord.Quality,
+		bass:    chord.Bass.transpose(change, key),
+		naming:  chord.naming}, true
+}
+
+//IsMinor returns true if this Chord has a minor quality (e.g. "m", "m7" or
+//"min"), false otherwise.
+func (chord Chord) IsMinor() bool {
+	return strings.HasPrefix(chord.Quality, "m") && !strings.HasPrefix(chord.Quality, "maj")
+}
+
+//IsValid returns true if this Note has a letter assigned, false otherwise.
+func (note Note) IsValid() bool {
+	return note.Letter >= 'A' && note.Letter <= 'G'
+}
+
+//Pitch returns the pitch class of this Note, from 0 (C) to 11 (B).
+func (note Note) Pitch() int {
+	return mod12(letterPitches[note.Letter] + note.Accidental)
+}
+
+//String returns the Note name, using '#' for sharps and 'b' for flats.
+func (note Note) String() string {
+	if !note.IsValid() {
+		return ""
+	}
+
+	return string(note.Letter) + accidentalSuffix(note.Accidental)
+}
+
+func accidentalSuffix(acc int) string {
+	if acc > 0 {
+		return strings.Repeat("#", acc)
+	}
+
+	return strings.Repeat("b", -acc)
+}
+
+//transpose returns the Note <change> half-notes away from this one,
+//spelled to suit the given Key.
+func (note Note) transpose(change int, key Key) Note {
+	if !note.IsValid() || change%12 == 0 {
+		return note
+	}
+
+	return key.Spell(note.Pitch() + change)
+}
+
+var letterPitches = map[byte]int{
+	'C': 0,
+	'D': 2,
+	'E': 4,
+	'F': 5,
+	'G': 7,
+	'A': 9,
+	'B': 11,
+}
+
+//defaultNotes is the spelling used for each pitch class (C = 0)
+//when the key is not known.
+var defaultNotes = []Note{
+	{'C', 0},
+	{'C', 1},
+	{'D', 0},
+	{'D', 1},
+	{'E', 0},
+	{'F', 0},
+	{'F', 1},
+	{'G', 0},
+	{'G', 1},
+	{'A', 0},
+	{'B', -1},
+	{'B', 0},
+}
+
+func mod12(n int) int {
+	return ((n % 12) + 12) % 12
+}
+
+//qualityRegex matches the quality of a chord, e.g. "m7b5" or "add9".
+//The quality may contain anything except spaces, separators and note letters,
+//so "G-C-G" is not mistaken for a G chord with a strange quality.
+var qualityRegex = regexp.MustCompile("^[^\\sA-H,|\\-]*$")
+
+//chordTokenRegex splits compound chord text (e.g. "G-C-G") into tokens
+//that might be chords.
+var chordTokenRegex = regexp.MustCompile("[^\\s,|\\-()]+")
+
+//parseChordName parses a single chord (e.g. "Bm7b5/F#") written in the
+//given NamingSystem into its root, quality and bass note.
+//NamingDefault tries each naming system in turn, see detectNamings.
+//ok is false if the text is not a single recognisable chord.
+func parseChordName(text string, naming NamingSystem) (name chordName, ok bool) {
+	if naming == NamingDefault {
+		for _, n := range detectNamings {
+			if name, ok = parseChordName(text, n); ok {
+				return name, true
 			}
 		}
 
-		if len(k) > 1 {
-			i += len(k) - 1
+		return chordName{}, false
+	}
+
+	root, rest, ok := parseNoteName(text, naming)
+	if !ok {
+		return chordName{}, false
+	}
+
+	name = chordName{root: root, naming: naming}
+
+	//a slash is only a bass note if a note follows it, e.g. not "C6/9"
+	if i := strings.LastIndex(rest, "/"); i >= 0 {
+		if bass, r, ok := parseNoteName(rest[i+1:], naming); ok && len(r) == 0 {
+			name.bass = bass
+			rest = rest[0:i]
 		}
 	}
 
-	return newKey
+	if !qualityRegex.MatchString(rest) {
+		return chordName{}, false
+	}
+	name.quality = rest
+
+	return name, true
+}
+
+//render returns the chord transposed by <change> half-notes, spelled to suit
+//<key> and written with the given NamingSystem (NamingDefault keeps the
+//naming system the chord was written in).
+func (name chordName) render(change int, key Key, naming NamingSystem) string {
+	if naming == NamingDefault {
+		naming = name.naming
+	}
+
+	text := name.root.transpose(change, key).Name(naming) + name.quality
+	if name.bass.IsValid() {
+		text += "/" + name.bass.transpose(change, key).Name(naming)
+	}
+
+	return text
+}
+
+//transposeKey transposes the chords in the given <text> by <change> half-notes,
+//spelling the new notes to suit <key>. <from> is the NamingSystem the text is
+//written in and <to> the NamingSystem to write the chords with.
+//Any unknown text is skipped, as this allows for Chord text such as
+//"G-C-G" or "(G)" to be transposed.
+func transposeKey(text string, change int, key Key, from NamingSystem, to NamingSystem) string {
+	if change%12 == 0 && (to == NamingDefault || to == from) {
+		return text
+	}
+
+	return mapChordTokens(text, from, func(name chordName) string {
+		return name.render(change, key, to)
+	})
+}
+
+//mapChordTokens replaces each recognisable chord in <text> with the result
+//of calling fn with the parsed chord, leaving any other text unchanged.
+func mapChordTokens(text string, naming NamingSystem, fn func(name chordName) string) string {
+	return chordTokenRegex.ReplaceAllStringFunc(text, func(token string) string {
+		name, ok := parseChordName(token, naming)
+		if !ok {
+			return token
+		}
+
+		return fn(name)
+	})
 }
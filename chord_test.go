@@ -45,3 +45,241 @@ func TestChordTransposition(t *testing.T) {
 		}
 	}
 }
+
+var parseChordTests = []struct {
+	in      string
+	root    Note
+	quality string
+	bass    Note
+}{
+	{"A", Note{'A', 0}, "", Note{}},
+	{"Bb", Note{'B', -1}, "", Note{}},
+	{"F#m", Note{'F', 1}, "m", Note{}},
+	{"Cadd9", Note{'C', 0}, "add9", Note{}},
+	{"Bm7b5/F#", Note{'B', 0}, "m7b5", Note{'F', 1}},
+	{"C/E", Note{'C', 0}, "", Note{'E', 0}},
+	{"C6/9", Note{'C', 0}, "6/9", Note{}},
+	{"Ebmaj7", Note{'E', -1}, "maj7", Note{}},
+	{"G-C-G", Note{}, "", Note{}},
+	{"N.C.", Note{}, "", Note{}},
+}
+
+func TestParseChord(t *testing.T) {
+	for _, ct := range parseChordTests {
+		actual := NewChord(ct.in, 0, 0)
+
+		if actual.Root != ct.root || actual.Quality != ct.quality || actual.Bass != ct.bass {
+			t.Errorf("Chord(%s), expected %v %q %v, actual %v %q %v", ct.in, ct.root, ct.quality, ct.bass, actual.Root, actual.Quality, actual.Bass)
+		}
+	}
+}
+
+var parsedTransposeTests = []struct {
+	in        string
+	transpose int
+	expected  string
+}{
+	{"Bm7b5/F#", 0, "Bm7b5/F#"},
+	{"Bm7b5/F#", 2, "C#m7b5/G#"},
+	{"Cadd9", 2, "Dadd9"},
+	{"Cadd9", -1, "Badd9"},
+	{"Dsus4", 3, "Fsus4"},
+	{"C/E", 5, "F/A"},
+	{"Bbmaj7", 1, "Bmaj7"},
+	{"N.C.", 2, "N.C."},
+	{"(G)", 2, "(A)"},
+}
+
+func TestParsedChordTransposition(t *testing.T) {
+	for _, ct := range parsedTransposeTests {
+		actual := NewChord(ct.in, 0, ct.transpose).GetText()
+
+		if actual != ct.expected {
+			t.Errorf("Chord(%s:%d), expected %v, actual %v", ct.in, ct.transpose, ct.expected, actual)
+		}
+	}
+}
+
+var keySpellingTests = []struct {
+	in        string
+	transpose int
+	key       string
+	expected  string
+}{
+	{"C", 3, "Eb", "Eb"},
+	{"G", 3, "Bb", "Bb"},
+	{"D7", 3, "Bb", "F7"},
+	{"A", 1, "Bb", "Bb"},
+	{"G", 1, "Ab", "Ab"},
+	{"G", 1, "E", "G#"},
+	{"F", 5, "D", "A#"},
+	{"Bb", 1, "Gb", "Cb"},
+	{"E", 1, "F#", "E#"},
+	{"Bm7b5/F#", 1, "Eb", "Cm7b5/G"},
+	{"D/F#", 2, "E", "E/G#"},
+	{"D/F#", 1, "Eb", "Eb/G"},
+	{"G-C-G", 3, "Bb", "Bb-Eb-Bb"},
+}
+
+func TestKeySpelling(t *testing.T) {
+	for _, ct := range keySpellingTests {
+		key, _ := ParseKey(ct.key)
+		chord := NewChord(ct.in, 0, ct.transpose)
+		chord.Key = key
+
+		actual := chord.GetText()
+		if actual != ct.expected {
+			t.Errorf("Chord(%s:%d in %s), expected %v, actual %v", ct.in, ct.transpose, ct.key, ct.expected, actual)
+		}
+	}
+}
+
+var keyTransposeTests = []struct {
+	in        string
+	transpose int
+	expected  string
+}{
+	{"G", 3, "Bb"},
+	{"C", 3, "Eb"},
+	{"E", -1, "Eb"},
+	{"D", 4, "F#"},
+	{"F", 1, "Gb"},
+	{"E", 2, "F#"},
+	{"Am", 3, "Cm"},
+	{"Em", -1, "D#m"},
+	{"Fm", -2, "Ebm"},
+	{"Db", 12, "Db"},
+	{"C#", 0, "C#"},
+}
+
+func TestKeyTranspose(t *testing.T) {
+	for _, ct := range keyTransposeTests {
+		key, ok := ParseKey(ct.in)
+		if !ok {
+			t.Errorf("Key(%s) could not be parsed", ct.in)
+			continue
+		}
+
+		actual := key.Transpose(ct.transpose).String()
+		if actual != ct.expected {
+			t.Errorf("Key(%s:%d), expected %v, actual %v", ct.in, ct.transpose, ct.expected, actual)
+		}
+	}
+}
+
+var capoTests = []struct {
+	in          string
+	transpose   int
+	key         string
+	capo        int
+	showConcert bool
+	expected    string
+}{
+	{"Bb", 0, "Bb", 3, false, "G"},
+	{"Eb/G", 0, "Bb", 3, false, "C/E"},
+	{"Bb", 0, "Bb", 3, true, "G (Bb)"},
+	{"A", 1, "Bb", 3, false, "G"},
+	{"F#m", 0, "A", 2, false, "Em"},
+	{"G-C-G", 0, "G", 5, false, "D-G-D"},
+	{"N.C.", 0, "G", 5, true, "N.C. (N.C.)"},
+}
+
+func TestCapoShapes(t *testing.T) {
+	for _, ct := range capoTests {
+		key, _ := ParseKey(ct.key)
+		chord := NewChord(ct.in, 0, ct.transpose)
+		chord.Key = key
+		chord.Capo = ct.capo
+		chord.ShowConcert = ct.showConcert
+
+		actual := chord.GetText()
+		if actual != ct.expected {
+			t.Errorf("Chord(%s:%d capo %d), expected %v, actual %v", ct.in, ct.transpose, ct.capo, ct.expected, actual)
+		}
+	}
+}
+
+var notationTests = []struct {
+	in        string
+	transpose int
+	key       string
+	notation  Notation
+	expected  string
+}{
+	{"C", 0, "C", NotationNashville, "1"},
+	{"F", 0, "C", NotationNashville, "4"},
+	{"G/B", 0, "C", NotationNashville, "5/7"},
+	{"Am", 0, "C", NotationNashville, "6m"},
+	{"Bb", 0, "C", NotationNashville, "b7"},
+	{"D7", 0, "G", NotationNashville, "57"},
+	{"F#m7", 2, "E", NotationNashville, "2m7"},
+	{"G-C-G", 0, "G", NotationNashville, "1-4-1"},
+	{"C", 0, "C", NotationRoman, "I"},
+	{"F", 0, "C", NotationRoman, "IV"},
+	{"Am", 0, "C", NotationRoman, "vi"},
+	{"Dm7", 0, "C", NotationRoman, "ii7"},
+	{"Bdim", 0, "C", NotationRoman, "vii°"},
+	{"Eb", 0, "C", NotationRoman, "bIII"},
+	{"C", 0, "Am", NotationRoman, "bIII"},
+	{"Am", 0, "", NotationRoman, "Am"},
+	{"Am", 0, "C", NotationLetters, "Am"},
+}
+
+func TestChordNotation(t *testing.T) {
+	for _, ct := range notationTests {
+		key, _ := ParseKey(ct.key)
+		chord := NewChord(ct.in, 0, ct.transpose)
+		chord.Key = key.Transpose(ct.transpose)
+		chord.Notation = ct.notation
+
+		actual := chord.GetText()
+		if actual != ct.expected {
+			t.Errorf("Chord(%s in %s, %s), expected %v, actual %v", ct.in, ct.key, ct.notation, ct.expected, actual)
+		}
+	}
+}
+
+var namingTests = []struct {
+	in        string
+	from      NamingSystem
+	transpose int
+	to        NamingSystem
+	expected  string
+}{
+	{"H7", NamingDefault, 0, NamingEnglish, "B7"},
+	{"Hm", NamingGerman, 2, NamingDefault, "C#m"},
+	{"B", NamingGerman, 0, NamingEnglish, "Bb"},
+	{"Bb", NamingEnglish, 0, NamingGerman, "B"},
+	{"B", NamingEnglish, 0, NamingGerman, "H"},
+	{"Fis", NamingGerman, 1, NamingDefault, "G"},
+	{"Es", NamingGerman, 0, NamingEnglish, "Eb"},
+	{"Esus4", NamingGerman, 0, NamingEnglish, "Esus4"},
+	{"Sol#", NamingDefault, 0, NamingEnglish, "G#"},
+	{"Do", NamingDefault, 2, NamingDefault, "Re"},
+	{"Rem7/Do", NamingDefault, 2, NamingDefault, "Mim7/Re"},
+	{"G", NamingEnglish, 0, NamingSolfege, "Sol"},
+	{"Fadd9", NamingDefault, 2, NamingDefault, "Gadd9"},
+	{"Fa", NamingDefault, 2, NamingDefault, "Sol"},
+	{"Do-Fa-Do", NamingDefault, 0, NamingEnglish, "C-F-C"},
+}
+
+func TestChordNaming(t *testing.T) {
+	for _, ct := range namingTests {
+		chord := newNamedChord(ct.in, 0, ct.transpose, ct.from)
+		chord.Naming = ct.to
+
+		actual := chord.GetText()
+		if actual != ct.expected {
+			t.Errorf("Chord(%s:%d %v->%v), expected %v, actual %v", ct.in, ct.transpose, ct.from, ct.to, ct.expected, actual)
+		}
+	}
+}
+
+func TestParseKeyNaming(t *testing.T) {
+	for in, expected := range map[string]string{"H": "B", "Hm": "Bm", "Sol": "G", "Es": "Eb", "F# minor": "F#m"} {
+		key, ok := ParseKey(in)
+		if !ok || key.String() != expected {
+			t.Errorf("Key(%s), expected %v, actual %v", in, expected, key)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//command is an isb sub-command, run as "isb <name> [args]".
+//Run returns the exit code.
+type command struct {
+	Name    string
+	Summary string
+	Run     func(args []string) int
+}
+
+//commands lists the isb sub-commands, in the order they are listed in the usage.
+var commands = []command{
+	{"serve", "serve the song book web site (the default)", runServe},
+	{"render", "render a song or songbook as a PDF", runRender},
+	{"export", "render every songbook (and optionally every song) as PDFs", runExport},
+	{"fmt", "tidy up song files", runFmt},
+	{"lint", "check song files for common mistakes", runLint},
+	{"user", "add, change or remove a user who can log in", runUser},
+}
+
+//runCommand runs the sub-command named by args[0], returning the exit code.
+//With no command (or only flags) the web site is served.
+func runCommand(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && !isHelp(args[0]) {
+		return runServe(args)
+	}
+
+	if isHelp(args[0]) {
+		printUsage(os.Stdout)
+		return 0
+	}
+
+	for _, c := range commands {
+		if c.Name == args[0] {
+			return c.Run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "isb: unknown command %q\n", args[0])
+	printUsage(os.Stderr)
+	return 2
+}
+
+func isHelp(arg string) bool {
+	switch arg {
+	case "help", "-h", "-help", "--help":
+		return true
+	}
+
+	return false
+}
+
+func printUsage(w *os.File) {
+	fmt.Fprintln(w, "usage: isb [command] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.Name, c.Summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Use \"isb <command> -h\" for the arguments of a command.")
+}
+
+//parseFlags parses the flags in <args>, which may come before or after the
+//other arguments (e.g. "render song a.song -o a.pdf"), and returns the
+//other arguments.
+func parseFlags(flags *flag.FlagSet, args []string) []string {
+	rest := make([]string, 0)
+
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return rest
+		}
+
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+}
+
+//flagOptions returns the display options (see applySongOptions) given by the
+//parsed flags. Flags that are not set (or are set to false) are not given.
+func flagOptions(flags *flag.FlagSet) func(name string) string {
+	set := make(map[string]string)
+
+	flags.Visit(func(f *flag.Flag) {
+		if g, ok := f.Value.(flag.Getter); ok {
+			if b, ok := g.Get().(bool); ok && !b {
+				return
+			}
+		}
+
+		set[f.Name] = f.Value.String()
+	})
+
+	return func(name string) string {
+		return set[name]
+	}
+}
+
+//addBookFlags adds the display options used for whole songbooks.
+func addBookFlags(flags *flag.FlagSet) {
+	flags.String("notation", "", "show chords as `letters`, nashville or roman numerals")
+	flags.String("naming", "", "show chords with the `naming` system english, german or solfege")
+	flags.String("diagrams", "", "add chord diagrams for the `instrument` guitar or ukulele")
+}
+
+//addSongFlags adds the display options used for single songs.
+func addSongFlags(flags *flag.FlagSet) {
+	flags.Int("transpose", 0, "transpose by `n` half-notes")
+	flags.String("key", "", "transpose to the `key`, e.g. G or Em")
+	flags.Int("capo", 0, "show the chord shapes to play with a capo on `fret`")
+	flags.Bool("concert", false, "show the sounding chords alongside the capo shapes")
+	addBookFlags(flags)
+}
+
+//runRender runs the "render" command, which renders a single song
+//("render song") or songbook ("render book") as a PDF.
+func runRender(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "song":
+			return renderSong(args[1:])
+		case "book":
+			return renderBook(args[1:])
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "usage: isb render song <file.song> [-o out.pdf] [options]")
+	fmt.Fprintln(os.Stderr, "       isb render book <file.songlist|book> [-version print|electronic] [-o out.pdf] [options]")
+	return 2
+}
+
+func renderSong(args []string) int {
+	flags := flag.NewFlagSet("render song", flag.ExitOnError)
+	out := flags.String("o", "", "write the PDF to `file` (\"-\" for standard output), by default <song>.pdf")
+	addSongFlags(flags)
+	cf := addConfigFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: isb render song <file.song> [-o out.pdf] [options]")
+		flags.PrintDefaults()
+	}
+
+	files := parseFlags(flags, args)
+	if len(files) != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	if err := cf.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	song, err := ParseSongFile(files[0], 0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	applySongOptions(song, flagOptions(flags))
+
+	pdf, err := WriteSongPDF(song)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if len(*out) == 0 {
+		*out = pdfName(files[0], "")
+	}
+
+	return writeOutput(*out, pdf)
+}
+
+func renderBook(args []string) int {
+	flags := flag.NewFlagSet("render book", flag.ExitOnError)
+	out := flags.String("o", "", "write the PDF to `file` (\"-\" for standard output), by default <book>.pdf")
+	version := flags.String("version", "print", "the `version` of the songbook, print or electronic")
+	addBookFlags(flags)
+	cf := addConfigFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: isb render book <file.songlist|book> [-version print|electronic] [-o out.pdf] [options]")
+		flags.PrintDefaults()
+	}
+
+	books := parseFlags(flags, args)
+	if len(books) != 1 || *version != "print" && *version != "electronic" {
+		flags.Usage()
+		return 2
+	}
+
+	if err := cf.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if len(*out) == 0 {
+		*out = pdfName(books[0], "")
+	}
+
+	return exportBook(songbookFilename(books[0]), *version, *out, flagOptions(flags))
+}
+
+//runExport runs the "export" command, which renders the given songbooks
+//(by default every songbook) as PDFs into a directory, for example to
+//publish the songbooks from a build pipeline.
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dir := flags.String("o", ".", "write the PDFs into `directory`")
+	version := flags.String("version", "all", "the `version` of the songbooks, print, electronic or all")
+	songs := flags.Bool("songs", false, "also render every song into <directory>/songs")
+	addBookFlags(flags)
+	cf := addConfigFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: isb export [-o directory] [-version print|electronic|all] [-songs] [options] [file.songlist|book ...]")
+		flags.PrintDefaults()
+	}
+
+	books := parseFlags(flags, args)
+
+	if err := cf.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	versions := []string{*version}
+	switch *version {
+	case "all":
+		versions = []string{"print", "electronic"}
+	case "print", "electronic":
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	if len(books) == 0 {
+		matches, err := filepath.Glob(filepath.Join(books_root, "*.songlist"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		books = matches
+	}
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	options := flagOptions(flags)
+	code := 0
+
+	for _, b := range books {
+		for _, v := range versions {
+			suffix := ""
+			if v == "electronic" {
+				suffix = "-electronic"
+			}
+
+			out := filepath.Join(*dir, pdfName(b, suffix))
+			if exportBook(songbookFilename(b), v, out, options) != 0 {
+				code = 1
+			}
+		}
+	}
+
+	if *songs {
+		files, err := songFiles([]string{songs_root})
+		if err == nil {
+			err = os.MkdirAll(filepath.Join(*dir, "songs"), 0755)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		for _, f := range files {
+			song, err := ParseSongFile(f, 0)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				code = 1
+				continue
+			}
+
+			applyBookOptions(song, options)
+
+			pdf, err := WriteSongPDF(song)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				code = 1
+				continue
+			}
+
+			if writeOutput(filepath.Join(*dir, "songs", pdfName(f, "")), pdf) != 0 {
+				code = 1
+			}
+		}
+	}
+
+	return code
+}
+
+//exportBook renders the songbook read from <filename> as a PDF of the given
+//version ("print" or "electronic"), writing it to <out>.
+//Returns the exit code.
+func exportBook(filename string, version string, out string, options func(name string) string) int {
+	sbook, err := ParseSongbookFile(filename, songs_root)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for i, song := range sbook.Songs {
+		applyBookOptions(&song, options)
+		sbook.Songs[i] = song
+	}
+
+	var pdf *bytes.Buffer
+	if version == "electronic" {
+		pdf, err = WriteBookPDFElectronic(sbook)
+	} else {
+		pdf, err = WriteBookPDF(sbook)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return writeOutput(out, pdf)
+}
+
+//songbookFilename returns the .songlist file for the given argument, which is
+//either a file, or the name of a songbook in the books directory.
+func songbookFilename(book string) string {
+	if _, err := os.Stat(book); err == nil {
+		return book
+	}
+
+	return books_root + "/" + strings.TrimSuffix(book, ".songlist") + ".songlist"
+}
+
+//pdfName returns the file name (without directory) of the PDF for the given
+//song or songbook file, e.g. "amazing.pdf" for "songs/amazing.song".
+func pdfName(file string, suffix string) string {
+	name := filepath.Base(file)
+	return strings.TrimSuffix(name, filepath.Ext(name)) + suffix + ".pdf"
+}
+
+//writeOutput writes the PDF to the given file, or to standard output for "-".
+//Returns the exit code.
+func writeOutput(out string, pdf *bytes.Buffer) int {
+	var err error
+	if out == "-" {
+		_, err = pdf.WriteTo(os.Stdout)
+	} else {
+		err = ioutil.WriteFile(out, pdf.Bytes(), 0644)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
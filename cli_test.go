@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+var parseFlagsTests = []struct {
+	in       []string
+	rest     []string
+	options  map[string]string
+	expected string
+}{
+	{[]string{"a.song"}, []string{"a.song"}, map[string]string{"transpose": "", "concert": ""}, ""},
+	{[]string{"a.song", "-o", "a.pdf", "-transpose", "2"}, []string{"a.song"}, map[string]string{"transpose": "2", "key": ""}, "a.pdf"},
+	{[]string{"--capo=3", "a.song", "--concert", "b.song"}, []string{"a.song", "b.song"}, map[string]string{"capo": "3", "concert": "true"}, ""},
+	{[]string{"-concert=false", "a.song"}, []string{"a.song"}, map[string]string{"concert": ""}, ""},
+}
+
+func TestParseFlags(t *testing.T) {
+	for _, pt := range parseFlagsTests {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		out := flags.String("o", "", "")
+		addSongFlags(flags)
+
+		rest := parseFlags(flags, pt.in)
+		if !reflect.DeepEqual(rest, pt.rest) {
+			t.Errorf("%v: expected arguments %v, actual %v", pt.in, pt.rest, rest)
+		}
+
+		if *out != pt.expected {
+			t.Errorf("%v: expected -o %q, actual %q", pt.in, pt.expected, *out)
+		}
+
+		option := flagOptions(flags)
+		for name, value := range pt.options {
+			if option(name) != value {
+				t.Errorf("%v: expected option %s %q, actual %q", pt.in, name, value, option(name))
+			}
+		}
+	}
+}
+
+var pdfNameTests = []struct {
+	file     string
+	suffix   string
+	expected string
+}{
+	{"songs/amazing.song", "", "amazing.pdf"},
+	{"books/test.songlist", "-electronic", "test-electronic.pdf"},
+	{"test", "", "test.pdf"},
+}
+
+func TestPdfName(t *testing.T) {
+	for _, pt := range pdfNameTests {
+		actual := pdfName(pt.file, pt.suffix)
+		if actual != pt.expected {
+			t.Errorf("%s: expected %s, actual %s", pt.file, pt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+//Config is the configuration of isb, read from (in increasing order of
+//precedence) the defaults, a config file, ISB_* environment variables and
+//command line flags.
+type Config struct {
+	Listen       string
+	SongsDir     string
+	BooksDir     string
+	TemplatesDir string
+	StaticDir    string
+	FontDir      string
+	Font         string
+	HeadingFont  string
+	PageSize     string
+	ReadOnly     bool
+	LogFile      string
+	LogRequests  bool
+	Watch        string
+	PollInterval time.Duration
+	UsersFile    string
+	HistoryDir   string
+}
+
+//config is the configuration in use, set by configFlags.Load.
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Listen:       ":8090",
+		SongsDir:     "./songs",
+		BooksDir:     "./books",
+		TemplatesDir: "templates",
+		StaticDir:    ".",
+		FontDir:      ".",
+		Font:         "Times",
+		HeadingFont:  "Helvetica",
+		PageSize:     "A4",
+		Watch:        "auto",
+		PollInterval: 2 * time.Second,
+		HistoryDir:   "./history",
+	}
+}
+
+//defaultConfigFile is read if it exists and no other config file is given.
+const defaultConfigFile = "isb.toml"
+
+//configSetting is a single setting of the Config. The setting is named
+//e.g. "songs_dir" in the config file, ISB_SONGS_DIR in the environment and
+//-songs-dir on the command line.
+type configSetting struct {
+	Name   string
+	Usage  string
+	IsBool bool
+	set    func(c *Config, value string) error
+}
+
+var configSettings = []configSetting{
+	{"listen", "listen on `address`, e.g. :8090 or localhost:8090", false,
+		func(c *Config, v string) error { c.Listen = v; return nil }},
+	{"songs_dir", "read the songs from `directory`", false,
+		func(c *Config, v string) error { c.SongsDir = v; return nil }},
+	{"books_dir", "read the songbooks from `directory`", false,
+		func(c *Config, v string) error { c.BooksDir = v; return nil }},
+	{"templates_dir", "read the web page templates from `directory`", false,
+		func(c *Config, v string) error { c.TemplatesDir = v; return nil }},
+	{"static_dir", "serve css/ and js/ from `directory`", false,
+		func(c *Config, v string) error { c.StaticDir = v; return nil }},
+	{"font_dir", "read the PDF font files from `directory`", false,
+		func(c *Config, v string) error { c.FontDir = v; return nil }},
+	{"font", "PDF `font` for lyrics: Times, Helvetica or Courier", false,
+		func(c *Config, v string) error { return setPDFFontSetting(&c.Font, v) }},
+	{"heading_font", "PDF `font` for titles and chords: Times, Helvetica or Courier", false,
+		func(c *Config, v string) error { return setPDFFontSetting(&c.HeadingFont, v) }},
+	{"page_size", "PDF page `size`: A3, A4, A5, Letter or Legal", false,
+		func(c *Config, v string) error {
+			switch strings.ToLower(v) {
+			case "a3", "a4", "a5", "letter", "legal":
+				c.PageSize = v
+				return nil
+			}
+			return fmt.Errorf("unknown page size %q", v)
+		}},
+	{"read_only", "do not allow songs and songbooks to be changed", true,
+		func(c *Config, v string) (err error) { c.ReadOnly, err = strconv.ParseBool(v); return err }},
+	{"log_file", "append the log to `file` instead of standard error", false,
+		func(c *Config, v string) error { c.LogFile = v; return nil }},
+	{"log_requests", "log every web request", true,
+		func(c *Config, v string) (err error) { c.LogRequests, err = strconv.ParseBool(v); return err }},
+	{"watch", "reload changed songs and songbooks: `mode` auto (inotify if available), poll or off", false,
+		func(c *Config, v string) error {
+			switch v {
+			case "auto", "poll", "off":
+				c.Watch = v
+				return nil
+			}
+			return fmt.Errorf("unknown watch mode %q", v)
+		}},
+	{"poll_interval", "check for changed songs and songbooks every `duration` when polling", false,
+		func(c *Config, v string) (err error) {
+			c.PollInterval, err = time.ParseDuration(v)
+			if err == nil && c.PollInterval <= 0 {
+				err = fmt.Errorf("poll interval must be positive")
+			}
+			return err
+		}},
+	{"users_file", "only let the users in `file` (see \"isb user\") change songs and songbooks", false,
+		func(c *Config, v string) error { c.UsersFile = v; return nil }},
+	{"history_dir", "keep every version of the songs and songbooks in `directory` (empty to not keep them)", false,
+		func(c *Config, v string) error { c.HistoryDir = v; return nil }},
+}
+
+//setPDFFontSetting sets <font> to one of the core PDF fonts.
+func setPDFFontSetting(font *string, value string) error {
+	for _, f := range []string{"Times", "Helvetica", "Courier"} {
+		if strings.EqualFold(value, f) {
+			*font = f
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown font %q", value)
+}
+
+//flagName returns the command line flag for this setting, e.g. "songs-dir".
+func (s configSetting) flagName() string {
+	return strings.Replace(s.Name, "_", "-", -1)
+}
+
+//envName returns the environment variable for this setting, e.g. "ISB_SONGS_DIR".
+func (s configSetting) envName() string {
+	return "ISB_" + strings.ToUpper(s.Name)
+}
+
+//configFlags are the Config settings given on the command line.
+type configFlags struct {
+	file   string
+	values map[string]string
+}
+
+//configFlag is the flag.Value of a single setting.
+type configFlag struct {
+	setting configSetting
+	flags   *configFlags
+}
+
+func (f configFlag) String() string {
+	if f.flags == nil {
+		return ""
+	}
+	return f.flags.values[f.setting.Name]
+}
+
+func (f configFlag) Set(value string) error {
+	f.flags.values[f.setting.Name] = value
+	return nil
+}
+
+func (f configFlag) IsBoolFlag() bool {
+	return f.setting.IsBool
+}
+
+//addConfigFlags adds a flag for each Config setting (and -config for the
+//config file) to the given flags. Load the Config once the flags are parsed.
+func addConfigFlags(flags *flag.FlagSet) *configFlags {
+	cf := &configFlags{values: make(map[string]string)}
+
+	flags.StringVar(&cf.file, "config", "", "read the configuration from `file` (default "+defaultConfigFile+" if it exists)")
+	for _, s := range configSettings {
+		flags.Var(configFlag{s, cf}, s.flagName(), s.Usage)
+	}
+
+	return cf
+}
+
+//Load reads the Config (see Config) and puts it into use.
+func (cf *configFlags) Load() error {
+	c, err := readConfig(cf.file, os.Getenv, cf.values)
+	if err != nil {
+		return err
+	}
+
+	return useConfig(c)
+}
+
+//readConfig returns the Config read from the defaults, the given config file
+//(the default file if it is "" and exists), the environment (read with
+//<getenv>) and the given flag values, in that order.
+func readConfig(file string, getenv func(string) string, flags map[string]string) (Config, error) {
+	c := defaultConfig()
+
+	if len(file) == 0 {
+		file = getenv("ISB_CONFIG")
+	}
+	if len(file) == 0 {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			file = defaultConfigFile
+		}
+	}
+
+	if len(file) > 0 {
+		f, err := os.Open(file)
+		if err != nil {
+			return c, err
+		}
+		defer f.Close()
+
+		values, err := parseConfigFile(f, file)
+		if err != nil {
+			return c, err
+		}
+
+		for _, s := range configSettings {
+			if v, ok := values[s.Name]; ok {
+				if err := s.set(&c, v); err != nil {
+					return c, fmt.Errorf("%s: %s: %s", file, s.Name, err)
+				}
+			}
+		}
+	}
+
+	for _, s := range configSettings {
+		if v := getenv(s.envName()); len(v) > 0 {
+			if err := s.set(&c, v); err != nil {
+				return c, fmt.Errorf("%s: %s", s.envName(), err)
+			}
+		}
+	}
+
+	for _, s := range configSettings {
+		if v, ok := flags[s.Name]; ok {
+			if err := s.set(&c, v); err != nil {
+				return c, fmt.Errorf("-%s: %s", s.flagName(), err)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+//parseConfigFile reads the settings from a config file, which uses the
+//"key = value" lines of TOML, e.g. songs_dir = "/srv/songs".
+//Returns the values by key. Unknown keys are an error.
+func parseConfigFile(r io.Reader, filename string) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("%s:%d: expected key = value", filename, lineNum)
+		}
+
+		key := strings.TrimSpace(line[:eq])
+		value, err := parseConfigValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", filename, lineNum, err)
+		}
+
+		known := false
+		for _, s := range configSettings {
+			known = known || s.Name == key
+		}
+		if !known {
+			return nil, fmt.Errorf("%s:%d: unknown setting %q", filename, lineNum, key)
+		}
+
+		values[key] = value
+	}
+
+	return values, scanner.Err()
+}
+
+//parseConfigValue returns the value of a TOML string ("basic" or 'literal'),
+//number or boolean, with any comment after it removed.
+func parseConfigValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := 1
+		for end < len(value) && value[end] != '"' {
+			if value[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(value) {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if rest := strings.TrimSpace(value[end+1:]); len(rest) > 0 && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after string", rest)
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		end := strings.Index(value[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if rest := strings.TrimSpace(value[end+2:]); len(rest) > 0 && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after string", rest)
+		}
+		return value[1 : end+1], nil
+	}
+
+	if i := strings.Index(value, "#"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	if len(value) == 0 {
+		return "", fmt.Errorf("missing value")
+	}
+
+	return value, nil
+}
+
+//useConfig puts the given Config into use: resolving the data directories
+//through any symlinks, setting up the PDF output and the log.
+func useConfig(c Config) error {
+	for _, dir := range []*string{&c.SongsDir, &c.BooksDir, &c.TemplatesDir, &c.StaticDir, &c.FontDir, &c.HistoryDir} {
+		if len(*dir) == 0 {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(*dir); err == nil {
+			*dir = resolved
+		}
+	}
+
+	if len(c.LogFile) > 0 {
+		f, err := os.OpenFile(c.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		log.SetOutput(f)
+	}
+
+	config = c
+	songs_root = c.SongsDir
+	books_root = c.BooksDir
+	setPDFFonts(c.Font, c.HeadingFont)
+	pdfPageSize = c.PageSize
+	pdfFontDir = c.FontDir
+
+	return nil
+}
+
+//templateFiles returns the paths of the named templates in the templates directory.
+func templateFiles(names ...string) []string {
+	files := make([]string, len(names))
+	for i, n := range names {
+		files[i] = filepath.Join(config.TemplatesDir, n)
+	}
+
+	return files
+}
+
+//writable wraps a handler that changes songs or songbooks, refusing the
+//request when the server is read-only.
+func writable(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if config.ReadOnly {
+			http.Error(w, "This song book is read-only.", http.StatusForbidden)
+			return
+		}
+
+		h(w, r, p)
+	}
+}
+
+//statusRecorder records the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+//logRequests wraps the handler, logging every request if configured to.
+func logRequests(h http.Handler) http.Handler {
+	if !config.LogRequests {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		url := r.URL.String()
+		rec := &statusRecorder{w, http.StatusOK}
+		h.ServeHTTP(rec, r)
+		log.Printf("%s %s %s %d %s", r.RemoteAddr, r.Method, url, rec.status, time.Since(start))
+	})
+}
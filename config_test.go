@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var configFileTests = []struct {
+	in       string
+	expected map[string]string
+	err      string
+}{
+	{"", map[string]string{}, ""},
+	{"# comment\n\nlisten = \":9000\"\nread_only = true # no editing\n",
+		map[string]string{"listen": ":9000", "read_only": "true"}, ""},
+	{"songs_dir = '/srv/songs'\nbooks_dir = \"C:\\\\books\" # comment\n",
+		map[string]string{"songs_dir": "/srv/songs", "books_dir": "C:\\books"}, ""},
+	{"port = 9000\n", nil, "isb.toml:1: unknown setting \"port\""},
+	{"listen\n", nil, "isb.toml:1: expected key = value"},
+	{"\nlisten = \":9000\n", nil, "isb.toml:2: unterminated string"},
+	{"listen = \":9000\" x\n", nil, "isb.toml:1: unexpected \"x\" after string"},
+}
+
+func TestParseConfigFile(t *testing.T) {
+	for _, ct := range configFileTests {
+		values, err := parseConfigFile(strings.NewReader(ct.in), "isb.toml")
+
+		if len(ct.err) > 0 {
+			if err == nil || err.Error() != ct.err {
+				t.Errorf("%q: expected error %q, actual %v", ct.in, ct.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", ct.in, err)
+		} else if !reflect.DeepEqual(values, ct.expected) {
+			t.Errorf("%q: expected %v, actual %v", ct.in, ct.expected, values)
+		}
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "isb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "isb.toml")
+	err = ioutil.WriteFile(file, []byte("listen = \":9000\"\nsongs_dir = \"file-songs\"\nbooks_dir = \"file-books\"\npage_size = \"letter\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{"ISB_SONGS_DIR": "env-songs", "ISB_BOOKS_DIR": "env-books", "ISB_READ_ONLY": "1"}
+	getenv := func(name string) string { return env[name] }
+	flags := map[string]string{"books_dir": "flag-books", "font": "helvetica"}
+
+	c, err := readConfig(file, getenv, flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := defaultConfig()
+	expected.Listen = ":9000"
+	expected.SongsDir = "env-songs"
+	expected.BooksDir = "flag-books"
+	expected.PageSize = "letter"
+	expected.ReadOnly = true
+	expected.Font = "Helvetica"
+
+	if c != expected {
+		t.Errorf("expected %+v, actual %+v", expected, c)
+	}
+
+	env["ISB_CONFIG"] = file
+	if c, err := readConfig("", getenv, nil); err != nil || c.Listen != ":9000" {
+		t.Errorf("ISB_CONFIG: expected listen :9000, actual %q (%v)", c.Listen, err)
+	}
+
+	for _, bad := range []map[string]string{{"page_size": "B5"}, {"font": "Comic Sans"}, {"read_only": "maybe"}} {
+		if _, err := readConfig(file, getenv, bad); err == nil {
+			t.Errorf("%v: expected an error", bad)
+		}
+	}
+}
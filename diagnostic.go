@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+//Severity is how serious a Diagnostic is.
+type Severity int
+
+const (
+	//SeverityWarning is a problem that does not change how the Song is read,
+	//e.g. an unknown tag which is ignored.
+	SeverityWarning Severity = 0
+	//SeverityError is a problem that means part of the Song could not be read.
+	SeverityError Severity = 1
+)
+
+func (severity Severity) String() string {
+	if severity == SeverityError {
+		return "error"
+	}
+
+	return "warning"
+}
+
+//MarshalJSON writes the Severity by name, i.e. "warning" or "error".
+func (severity Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(severity.String())
+}
+
+//Diagnostic is a problem found when reading a file.
+//Line and Column start from 1, Column counts characters (not bytes)
+//and is 0 if the problem is with the whole line, Line is 0 if the problem
+//is with the whole file. Rule is the name of the LintRule that found the
+//problem, "" for problems found when parsing.
+type Diagnostic struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	Rule     string   `json:"rule,omitempty"`
+}
+
+//String returns the Diagnostic in the form
+//"file:line:column: severity: message [rule]".
+func (diag Diagnostic) String() string {
+	pos := diag.File
+	if diag.Line > 0 {
+		pos += fmt.Sprintf(":%d", diag.Line)
+		if diag.Column > 0 {
+			pos += fmt.Sprintf(":%d", diag.Column)
+		}
+	}
+
+	text := fmt.Sprintf("%s: %s: %s", pos, diag.Severity, diag.Message)
+	if len(diag.Rule) > 0 {
+		text += " [" + diag.Rule + "]"
+	}
+
+	return text
+}
+
+//HasErrors returns true if any of the given Diagnostics is an error,
+//false otherwise.
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
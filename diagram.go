@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+)
+
+//ChordShape is a fingering for a chord on a fretted instrument.
+//Frets lists the fret to hold down on each string, from the lowest string,
+//0 for an open string and -1 for a string that is not played.
+type ChordShape struct {
+	Frets []int
+}
+
+//ChordDiagram is a named ChordShape, as drawn above or below a Song.
+type ChordDiagram struct {
+	Name  string
+	Shape ChordShape
+}
+
+//Instrument is a fretted instrument with a built-in library of chord shapes.
+//Shapes for common open chords are listed by name, any other chord is
+//built from the movable templates (e.g. barre chords).
+type Instrument struct {
+	Name      string
+	Title     string
+	Tuning    []int
+	shapes    map[string][]int
+	templates []shapeTemplate
+}
+
+//shapeTemplate is a movable chord shape.
+//The root of the chord is on string <root>, and frets are relative to the
+//fret the root is played on.
+type shapeTemplate struct {
+	root      int
+	qualities map[string][]int
+}
+
+//X marks a string that is not played
+const X = -1
+
+var Guitar = &Instrument{
+	Name:   "guitar",
+	Title:  "Guitar",
+	Tuning: []int{4, 9, 2, 7, 11, 4},
+	shapes: map[string][]int{
+		"C":     {X, 3, 2, 0, 1, 0},
+		"D":     {X, X, 0, 2, 3, 2},
+		"E":     {0, 2, 2, 1, 0, 0},
+		"F":     {1, 3, 3, 2, 1, 1},
+		"G":     {3, 2, 0, 0, 0, 3},
+		"A":     {X, 0, 2, 2, 2, 0},
+		"B":     {X, 2, 4, 4, 4, 2},
+		"Am":    {X, 0, 2, 2, 1, 0},
+		"Bm":    {X, 2, 4, 4, 3, 2},
+		"Dm":    {X, X, 0, 2, 3, 1},
+		"Em":    {0, 2, 2, 0, 0, 0},
+		"F#m":   {2, 4, 4, 2, 2, 2},
+		"A7":    {X, 0, 2, 0, 2, 0},
+		"B7":    {X, 2, 1, 2, 0, 2},
+		"C7":    {X, 3, 2, 3, 1, 0},
+		"D7":    {X, X, 0, 2, 1, 2},
+		"E7":    {0, 2, 0, 1, 0, 0},
+		"G7":    {3, 2, 0, 0, 0, 1},
+		"Am7":   {X, 0, 2, 0, 1, 0},
+		"Dm7":   {X, X, 0, 2, 1, 1},
+		"Em7":   {0, 2, 0, 0, 3, 0},
+		"Cmaj7": {X, 3, 2, 0, 0, 0},
+		"Dmaj7": {X, X, 0, 2, 2, 2},
+		"Fmaj7": {X, X, 3, 2, 1, 0},
+		"Gmaj7": {3, 2, 0, 0, 0, 2},
+		"Amaj7": {X, 0, 2, 1, 2, 0},
+		"Dsus4": {X, X, 0, 2, 3, 3},
+		"Dsus2": {X, X, 0, 2, 3, 0},
+		"Asus4": {X, 0, 2, 2, 3, 0},
+		"Asus2": {X, 0, 2, 2, 0, 0},
+		"Esus4": {0, 2, 2, 2, 0, 0},
+		"Cadd9": {X, 3, 2, 0, 3, 0},
+	},
+	templates: []shapeTemplate{
+		//E shapes, root on the low E string
+		{0, map[string][]int{
+			"":     {0, 2, 2, 1, 0, 0},
+			"m":    {0, 2, 2, 0, 0, 0},
+			"7":    {0, 2, 0, 1, 0, 0},
+			"m7":   {0, 2, 0, 0, 0, 0},
+			"maj7": {0, X, 1, 1, 0, X},
+			"sus4": {0, 2, 2, 2, 0, 0},
+			"6":    {0, 2, 2, 1, 2, 0},
+		}},
+		//A shapes, root on the A string
+		{1, map[string][]int{
+			"":     {X, 0, 2, 2, 2, 0},
+			"m":    {X, 0, 2, 2, 1, 0},
+			"7":    {X, 0, 2, 0, 2, 0},
+			"m7":   {X, 0, 2, 0, 1, 0},
+			"maj7": {X, 0, 2, 1, 2, 0},
+			"sus4": {X, 0, 2, 2, 3, 0},
+			"sus2": {X, 0, 2, 2, 0, 0},
+			"add9": {X, 0, 2, 4, 2, 0},
+			"m6":   {X, 0, 2, 2, 1, 2},
+			"m7b5": {X, 0, 1, 0, 1, X},
+			"dim":  {X, 0, 1, 2, 1, X},
+			"aug":  {X, 0, 3, 2, 2, 1},
+		}},
+	},
+}
+
+var Ukulele = &Instrument{
+	Name:   "ukulele",
+	Title:  "Ukulele",
+	Tuning: []int{7, 0, 4, 9},
+	shapes: map[string][]int{
+		"C":     {0, 0, 0, 3},
+		"D":     {2, 2, 2, 0},
+		"E":     {1, 4, 0, 2},
+		"F":     {2, 0, 1, 0},
+		"G":     {0, 2, 3, 2},
+		"A":     {2, 1, 0, 0},
+		"Bb":    {3, 2, 1, 1},
+		"Am":    {2, 0, 0, 0},
+		"Dm":    {2, 2, 1, 0},
+		"Em":    {0, 4, 3, 2},
+		"Gm":    {0, 2, 3, 1},
+		"F#m":   {2, 1, 2, 0},
+		"C7":    {0, 0, 0, 1},
+		"D7":    {2, 2, 2, 3},
+		"E7":    {1, 2, 0, 2},
+		"G7":    {0, 2, 1, 2},
+		"A7":    {0, 1, 0, 0},
+		"B7":    {2, 3, 2, 2},
+		"Am7":   {0, 0, 0, 0},
+		"Dm7":   {2, 2, 1, 3},
+		"Em7":   {0, 2, 0, 2},
+		"Cmaj7": {0, 0, 0, 2},
+		"Fmaj7": {2, 4, 1, 3},
+		"Gmaj7": {0, 2, 2, 2},
+		"Csus4": {0, 0, 1, 3},
+		"Dsus4": {0, 2, 3, 0},
+		"Gsus4": {0, 2, 3, 3},
+		"Asus4": {2, 2, 0, 0},
+		"Csus2": {0, 2, 3, 3},
+	},
+	templates: []shapeTemplate{
+		//A shapes, root on the A string
+		{3, map[string][]int{
+			"":     {2, 1, 0, 0},
+			"m":    {2, 0, 0, 0},
+			"7":    {0, 1, 0, 0},
+			"m7":   {0, 0, 0, 0},
+			"maj7": {1, 1, 0, 0},
+			"sus4": {2, 2, 0, 0},
+		}},
+		//C shapes, root on the C string
+		{1, map[string][]int{
+			"":     {0, 0, 0, 3},
+			"m":    {0, 3, 3, 3},
+			"7":    {0, 0, 0, 1},
+			"m7":   {3, 3, 3, 3},
+			"maj7": {0, 0, 0, 2},
+			"sus4": {0, 0, 1, 3},
+			"sus2": {0, 2, 3, 3},
+		}},
+	},
+}
+
+//Instruments lists the instruments with chord diagrams, in display order.
+var Instruments = []*Instrument{Guitar, Ukulele}
+
+//ParseInstrument returns the Instrument with the given name,
+//nil if there is no such instrument.
+func ParseInstrument(name string) *Instrument {
+	for _, i := range Instruments {
+		if strings.EqualFold(name, i.Name) {
+			return i
+		}
+	}
+
+	return nil
+}
+
+//qualityAliases maps alternative ways of writing a quality to the
+//names used in the shape library.
+var qualityAliases = map[string]string{
+	"maj":  "",
+	"M":    "",
+	"min":  "m",
+	"-":    "m",
+	"M7":   "maj7",
+	"Δ":    "maj7",
+	"Δ7":   "maj7",
+	"min7": "m7",
+	"sus":  "sus4",
+	"2":    "sus2",
+	"°":    "dim",
+	"o":    "dim",
+	"+":    "aug",
+	"ø":    "m7b5",
+	"ø7":   "m7b5",
+}
+
+//Shape returns the ChordShape for the given root and quality on this
+//Instrument, preferring open chords and then the movable shape played
+//lowest on the neck. ok is false if the chord is not in the library.
+func (inst *Instrument) Shape(root Note, quality string) (shape ChordShape, ok bool) {
+	if alias, found := qualityAliases[quality]; found {
+		quality = alias
+	}
+
+	if frets, found := inst.shapes[defaultNotes[root.Pitch()].String()+quality]; found {
+		return ChordShape{Frets: frets}, true
+	}
+
+	best := -1
+	for _, t := range inst.templates {
+		rel, found := t.qualities[quality]
+		if !found {
+			continue
+		}
+
+		fret := mod12(root.Pitch() - inst.Tuning[t.root])
+		if best >= 0 && fret >= best {
+			continue
+		}
+
+		best = fret
+		shape.Frets = make([]int, len(rel))
+		for i, f := range rel {
+			shape.Frets[i] = f
+			if f >= 0 {
+				shape.Frets[i] += fret
+			}
+		}
+	}
+
+	return shape, best >= 0
+}
+
+//parseDefine parses the value of a ChordPro {define} tag, e.g.
+//"Asus4 base-fret 1 frets x 0 2 2 3 0". Frets are relative to the base fret,
+//i.e. fret 1 is the base fret. Any finger positions given are ignored.
+//ok is false if the definition is not valid.
+func parseDefine(define string) (name string, shape ChordShape, ok bool) {
+	fields := strings.Fields(define)
+	if len(fields) < 2 {
+		return "", ChordShape{}, false
+	}
+
+	name = strings.TrimSuffix(fields[0], ":")
+	base := 1
+	frets := make([]int, 0)
+	inFrets := false
+
+	for i := 1; i < len(fields); i++ {
+		switch strings.ToLower(fields[i]) {
+		case "base-fret":
+			if i+1 >= len(fields) {
+				return "", ChordShape{}, false
+			}
+
+			b, err := strconv.Atoi(fields[i+1])
+			if err != nil || b < 1 {
+				return "", ChordShape{}, false
+			}
+			base = b
+			i++
+			inFrets = false
+		case "frets":
+			inFrets = true
+		case "fingers":
+			inFrets = false
+		default:
+			if !inFrets {
+				continue
+			}
+
+			if f := strings.ToLower(fields[i]); f == "x" || f == "n" || f == "-1" {
+				frets = append(frets, X)
+			} else if n, err := strconv.Atoi(f); err == nil && n >= 0 {
+				if n > 0 {
+					n += base - 1
+				}
+				frets = append(frets, n)
+			} else {
+				return "", ChordShape{}, false
+			}
+		}
+	}
+
+	if len(frets) == 0 {
+		return "", ChordShape{}, false
+	}
+
+	return name, ChordShape{Frets: frets}, true
+}
+
+//BaseFret returns the fret shown at the top of the diagram.
+//Shapes that fit within the first four frets start at the nut (fret 1).
+func (shape ChordShape) BaseFret() int {
+	min, max := 0, 0
+	for _, f := range shape.Frets {
+		if f > 0 && (min == 0 || f < min) {
+			min = f
+		}
+		if f > max {
+			max = f
+		}
+	}
+
+	if max <= 4 {
+		return 1
+	}
+
+	return min
+}
+
+//diagramFrets is the number of frets drawn in a diagram.
+const diagramFrets = 4
+
+//SVG returns an inline SVG drawing of this diagram.
+func (diagram ChordDiagram) SVG() template.HTML {
+	count := len(diagram.Shape.Frets)
+	spacing := 10.0
+	top := 26.0
+	left := 14.0
+	w := left*2 + spacing*float64(count-1)
+	h := top + spacing*diagramFrets + 6
+	base := diagram.Shape.BaseFret()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<svg class='chord-diagram' width='%g' height='%g' viewBox='0 0 %g %g'>", w, h, w, h)
+	fmt.Fprintf(&b, "<text x='%g' y='10' text-anchor='middle' font-size='11' font-weight='bold'>%s</text>",
+		w/2, template.HTMLEscapeString(diagram.Name))
+
+	//strings and frets
+	for i := 0; i < count; i++ {
+		sx := left + spacing*float64(i)
+		fmt.Fprintf(&b, "<line x1='%g' y1='%g' x2='%g' y2='%g' stroke='black'/>", sx, top, sx, top+spacing*diagramFrets)
+	}
+	for i := 0; i <= diagramFrets; i++ {
+		width := 1
+		if i == 0 && base == 1 {
+			width = 3
+		}
+		fy := top + spacing*float64(i)
+		fmt.Fprintf(&b, "<line x1='%g' y1='%g' x2='%g' y2='%g' stroke='black' stroke-width='%d'/>", left, fy, w-left, fy, width)
+	}
+	if base > 1 {
+		fmt.Fprintf(&b, "<text x='%g' y='%g' text-anchor='end' font-size='8'>%d</text>", left-4, top+spacing*0.75, base)
+	}
+
+	//finger positions
+	for i, f := range diagram.Shape.Frets {
+		sx := left + spacing*float64(i)
+		if f < 0 {
+			fmt.Fprintf(&b, "<text x='%g' y='%g' text-anchor='middle' font-size='8'>x</text>", sx, top-3)
+		} else if f == 0 {
+			fmt.Fprintf(&b, "<circle cx='%g' cy='%g' r='2.5' fill='none' stroke='black'/>", sx, top-6)
+		} else {
+			fy := top + spacing*(float64(f-base)+0.5)
+			fmt.Fprintf(&b, "<circle cx='%g' cy='%g' r='3.5' fill='black'/>", sx, fy)
+		}
+	}
+
+	b.WriteString("</svg>")
+
+	return template.HTML(b.String())
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var shapeTests = []struct {
+	instrument *Instrument
+	chord      string
+	expected   []int
+}{
+	{Guitar, "C", []int{X, 3, 2, 0, 1, 0}},
+	{Guitar, "Amin", []int{X, 0, 2, 2, 1, 0}},
+	{Guitar, "Db", []int{X, 4, 6, 6, 6, 4}},
+	{Guitar, "Abm7", []int{4, 6, 4, 4, 4, 4}},
+	{Guitar, "C#dim", []int{X, 4, 5, 6, 5, X}},
+	{Ukulele, "Am", []int{2, 0, 0, 0}},
+	{Ukulele, "Db", []int{1, 1, 1, 4}},
+	{Ukulele, "B", []int{4, 3, 2, 2}},
+	{Ukulele, "H", []int{4, 3, 2, 2}},
+}
+
+func TestInstrumentShape(t *testing.T) {
+	for _, st := range shapeTests {
+		c := NewChord(st.chord, 0, 0)
+		if st.chord == "H" {
+			c = newNamedChord(st.chord, 0, 0, NamingGerman)
+		}
+
+		shape, ok := st.instrument.Shape(c.Root, c.Quality)
+		if !ok || !reflect.DeepEqual(shape.Frets, st.expected) {
+			t.Errorf("%s %s: Expected %v, got %v (%v)", st.instrument.Name, st.chord, st.expected, shape.Frets, ok)
+		}
+	}
+
+	if _, ok := Guitar.Shape(Note{'C', 0}, "13b9"); ok {
+		t.Errorf("Expected no shape for C13b9")
+	}
+}
+
+var defineTests = []struct {
+	in       string
+	name     string
+	expected []int
+	base     int
+}{
+	{"Asus4 base-fret 1 frets x 0 2 2 3 0", "Asus4", []int{X, 0, 2, 2, 3, 0}, 1},
+	{"Bb base-fret 6 frets 1 1 3 3 3 1 fingers 1 1 2 3 4 1", "Bb", []int{6, 6, 8, 8, 8, 6}, 6},
+	{"G6 frets 0 2 2 2", "G6", []int{0, 2, 2, 2}, 1},
+}
+
+func TestParseDefine(t *testing.T) {
+	for _, dt := range defineTests {
+		name, shape, ok := parseDefine(dt.in)
+		if !ok || name != dt.name || !reflect.DeepEqual(shape.Frets, dt.expected) || shape.BaseFret() != dt.base {
+			t.Errorf("%s: Expected %s %v (base %d), got %s %v (base %d)",
+				dt.in, dt.name, dt.expected, dt.base, name, shape.Frets, shape.BaseFret())
+		}
+	}
+
+	for _, bad := range []string{"", "Asus4", "Asus4 frets x 0 q", "A base-fret 0 frets 1 2 3 4"} {
+		if _, _, ok := parseDefine(bad); ok {
+			t.Errorf("%s: Expected an invalid definition", bad)
+		}
+	}
+}
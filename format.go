@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//ErrNotFormatted is returned by FormatSong when a song has problems that
+//would be lost by formatting it.
+var ErrNotFormatted = errors.New("song has problems, not formatted")
+
+//FormatSong returns the given .song text in its canonical form:
+//directives are written in full and in lower case, stanzas are separated by
+//a single blank line, chord brackets have no spaces, trailing white space is
+//removed and lines end with "\n".
+//Songs with problems are not formatted, as the problem text would be lost,
+//instead the Diagnostics are returned with ErrNotFormatted.
+func FormatSong(src []byte, filename string) ([]byte, []Diagnostic, error) {
+	song, diags, err := ParseSong(bytes.NewReader(src), ParseOptions{Filename: filename})
+	if err != nil {
+		return nil, diags, err
+	}
+
+	if len(diags) > 0 {
+		return nil, diags, ErrNotFormatted
+	}
+
+	buf, err := WriteSongText(song)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lines := strings.Split(buf.String(), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t")
+	}
+
+	return []byte(strings.Join(lines, "\n")), nil, nil
+}
+
+//runFmt runs the "fmt" command, which formats the given .song files (or all
+//songs in the given directories, by default the songs directory) in place.
+//With -check the files are not changed, instead the files that need
+//formatting are listed. Returns the exit code.
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	check := flags.Bool("check", false, "list files that need formatting, without changing them")
+	cf := addConfigFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: isb fmt [-check] [file.song|directory ...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if err := cf.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{songs_root}
+	}
+
+	files, err := songFiles(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	code := 0
+	for _, f := range files {
+		src, err := ioutil.ReadFile(f)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 2
+			continue
+		}
+
+		formatted, diags, err := FormatSong(src, f)
+		for _, d := range diags {
+			fmt.Fprintln(os.Stderr, d)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", f, err)
+			code = 2
+			continue
+		}
+
+		if bytes.Equal(src, formatted) {
+			continue
+		}
+
+		if *check {
+			fmt.Println(f)
+			if code == 0 {
+				code = 1
+			}
+			continue
+		}
+
+		if err := ioutil.WriteFile(f, formatted, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 2
+		}
+	}
+
+	return code
+}
+
+//songFiles returns the .song files in the given paths, which may be files or
+//directories of songs.
+func songFiles(paths []string) ([]string, error) {
+	files := make([]string, 0)
+
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(p, "*.song"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	return files, nil
+}
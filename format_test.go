@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+var formatTests = []struct {
+	in       string
+	expected string
+}{
+	{
+		"{Title: Song}\r\n{Comments: Capo 1}  \r\n\r\n\r\n[ G ]Line one  \r\n{soc}\r\nChorus [C]line{echo:again}\r\n{eoc}\r\n",
+		"{title: Song}\n{comments: Capo 1}\n\n[G]Line one\n\n{start_of_chorus}\nChorus [C]line{echo: again}\n{end_of_chorus}\n",
+	},
+	{
+		"{t:Song}\r{c:Slowly}\r\rA line\r",
+		"{title: Song}\n{comments: Slowly}\n\nA line\n",
+	},
+	{
+		"{x_custom: kept}\nA line\n",
+		"{x_custom: kept}\n\nA line\n",
+	},
+}
+
+func TestFormatSong(t *testing.T) {
+	for _, ft := range formatTests {
+		out, diags, err := FormatSong([]byte(ft.in), "test.song")
+		if err != nil {
+			t.Errorf("%q: unexpected error %s %v", ft.in, err, diags)
+			continue
+		}
+
+		if string(out) != ft.expected {
+			t.Errorf("%q: expected\n%q\nactual\n%q", ft.in, ft.expected, out)
+		}
+
+		//formatting is stable
+		again, _, _ := FormatSong(out, "test.song")
+		if string(again) != string(out) {
+			t.Errorf("%q: formatting again changed\n%q\nto\n%q", ft.in, out, again)
+		}
+	}
+}
+
+func TestFormatSongProblems(t *testing.T) {
+	out, diags, err := FormatSong([]byte("{bogus}\nA line\n"), "test.song")
+	if err != ErrNotFormatted || out != nil || len(diags) != 1 {
+		t.Errorf("expected the song not to be formatted, actual %q %v %v", out, diags, err)
+	}
+}
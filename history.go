@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+//Revision is a version of a song or songbook file, kept in its History.
+//A Revision of a deleted file has no Content.
+type Revision struct {
+	Number  int       `json:"number"`
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author"`
+	Message string    `json:"message"`
+	Deleted bool      `json:"deleted,omitempty"`
+	Content string    `json:"content,omitempty"`
+}
+
+//ErrDeletedRevision is the error restoring a Revision that deleted the file.
+var ErrDeletedRevision = errors.New("the revision is a delete")
+
+//Change is who made a change to a file, and why, for its History.
+type Change struct {
+	Author  string
+	Message string
+}
+
+//History keeps every version of the song and songbook files, so that
+//changes can be looked back on and undone. Each file has a log in the
+//history directory (e.g. "songs/amazing.song.history") with a line of
+//JSON for each Revision, which is only ever added to.
+//A History is safe for concurrent use.
+type History struct {
+	dir string
+	mu  sync.Mutex
+}
+
+//history is the History of the web site, set by runServe unless there is
+//no history directory.
+var history *History
+
+//NewHistory returns the History kept in the given directory, which is
+//created when it is first written to.
+func NewHistory(dir string) *History {
+	return &History{dir: dir}
+}
+
+//historyDir returns the directory the history of the store's files is kept in.
+func historyDir(s fileStore) string {
+	return strings.TrimPrefix(s.Ext, ".") + "s"
+}
+
+//logPath returns the history log of the named file.
+func (h *History) logPath(s fileStore, name string) (string, error) {
+	if _, err := s.Path(name); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(h.dir, historyDir(s), name+s.Ext+".history"), nil
+}
+
+//Revisions returns every Revision of the named file, oldest first.
+func (h *History) Revisions(s fileStore, name string) ([]Revision, error) {
+	path, err := h.logPath(s, name)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return readRevisions(path)
+}
+
+//Revision returns the given Revision of the named file.
+func (h *History) Revision(s fileStore, name string, number int) (Revision, error) {
+	revs, err := h.Revisions(s, name)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	if number < 1 || number > len(revs) {
+		return Revision{}, &StoreError{"find", s.Kind + " revision", fmt.Sprintf("%s@%d", name, number), os.ErrNotExist}
+	}
+
+	return revs[number-1], nil
+}
+
+//Deleted returns the names of the files whose last Revision is a delete.
+func (h *History) Deleted(s fileStore) ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	logs, err := filepath.Glob(filepath.Join(h.dir, historyDir(s), "*"+s.Ext+".history"))
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := make([]string, 0)
+	for _, l := range logs {
+		revs, err := readRevisions(l)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(revs) > 0 && revs[len(revs)-1].Deleted {
+			deleted = append(deleted, strings.TrimSuffix(filepath.Base(l), s.Ext+".history"))
+		}
+	}
+
+	sort.Strings(deleted)
+	return deleted, nil
+}
+
+//keepExisting records the named file's current content as its first
+//Revision, if it has no history yet (e.g. it was there before the history
+//was kept, or was added by hand), so a change to it can be undone.
+func (h *History) keepExisting(s fileStore, name string) error {
+	path, err := h.logPath(s, name)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	revs, err := readRevisions(path)
+	if err != nil || len(revs) > 0 {
+		return err
+	}
+
+	existing, err := s.Read(name)
+	if err != nil {
+		//there is nothing to keep
+		return nil
+	}
+
+	return appendRevision(path, Revision{Number: 1, Time: time.Now(), Message: "Before the history was kept", Content: string(existing)})
+}
+
+//record adds a Revision of the named file to its log.
+//<content> is nil for a delete.
+func (h *History) record(s fileStore, name string, content []byte, change Change) error {
+	path, err := h.logPath(s, name)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	revs, err := readRevisions(path)
+	if err != nil {
+		return err
+	}
+
+	return appendRevision(path, Revision{
+		Number:  len(revs) + 1,
+		Time:    time.Now(),
+		Author:  change.Author,
+		Message: change.Message,
+		Deleted: content == nil,
+		Content: string(content),
+	})
+}
+
+func readRevisions(path string) ([]Revision, error) {
+	revs := make([]Revision, 0)
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return revs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*maxAPIBody)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var rev Revision
+		if err := json.Unmarshal(scanner.Bytes(), &rev); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		revs = append(revs, rev)
+	}
+
+	return revs, scanner.Err()
+}
+
+//appendRevision adds the Revision to the end of the log, in a single write.
+func appendRevision(path string, rev Revision) error {
+	line, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(line, '\n'))
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+//Save writes the named file (see Write), and records it in the History.
+func (s fileStore) Save(name string, content []byte, change Change) error {
+	return s.change(name, content, change, s.Write)
+}
+
+//SaveNew creates the named file (see Create), and records it in the History.
+func (s fileStore) SaveNew(name string, content []byte, change Change) error {
+	return s.change(name, content, change, s.Create)
+}
+
+//Delete removes the named file (see Remove), and records it in the History,
+//so it can be restored.
+func (s fileStore) Delete(name string, change Change) error {
+	return s.change(name, nil, change, func(name string, _ []byte) error {
+		return s.Remove(name)
+	})
+}
+
+//change makes a change to the named file, recording it in the History.
+//The file's current content is kept in the History first, if it has none,
+//so the change can always be undone.
+func (s fileStore) change(name string, content []byte, change Change, apply func(name string, content []byte) error) error {
+	if history == nil {
+		return apply(name, content)
+	}
+
+	if err := history.keepExisting(s, name); err != nil {
+		if _, ok := err.(*StoreError); ok {
+			return err
+		}
+		return &StoreError{"save", s.Kind, name, err}
+	}
+
+	if err := apply(name, content); err != nil {
+		return err
+	}
+
+	if err := history.record(s, name, content, change); err != nil {
+		return &StoreError{"record the history of", s.Kind, name, err}
+	}
+
+	return nil
+}
+
+//requestChange returns the Change made by the request: by the logged in
+//user (or the address the request came from), with the given message, or
+//<otherwise> if the message is empty.
+func requestChange(r *http.Request, message string, otherwise string) Change {
+	message = strings.TrimSpace(message)
+	if len(message) == 0 {
+		message = otherwise
+	}
+
+	return Change{Author: requestAuthor(r), Message: message}
+}
+
+//requestAuthor returns the logged in user making the request, or the
+//address the request came from.
+func requestAuthor(r *http.Request) string {
+	if auth != nil {
+		if s := auth.Session(r); s != nil {
+			return s.User
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
+
+//DiffLine is a line of a diff: Op is " " for a line in both versions, "-"
+//for a line only in the old version and "+" for a line only in the new.
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+//diffLines returns the lines of <from> and <to>, marked with how they
+//changed, using the longest common subsequence of lines.
+func diffLines(from string, to string) []DiffLine {
+	a := splitLines(from)
+	b := splitLines(to)
+
+	//common[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	diff := make([]DiffLine, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff = append(diff, DiffLine{" ", a[i]})
+			i++
+			j++
+		case j == len(b) || i < len(a) && common[i+1][j] >= common[i][j+1]:
+			diff = append(diff, DiffLine{"-", a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{"+", b[j]})
+			j++
+		}
+	}
+
+	return diff
+}
+
+//splitLines returns the lines of the text, without a final empty line.
+func splitLines(text string) []string {
+	if len(text) == 0 {
+		return []string{}
+	}
+
+	return strings.Split(strings.TrimSuffix(strings.Replace(text, "\r\n", "\n", -1), "\n"), "\n")
+}
+
+//historyKind is a kind of file with a History, as seen on the web site.
+type historyKind struct {
+	Store fileStore
+	//Param is the route parameter naming the file, and the start of its pages
+	Param  string
+	Reload func(names ...string) error
+}
+
+var (
+	songHistory = historyKind{songStore, "song", func(names ...string) error { return catalog.ReloadSongs(names...) }}
+	bookHistory = historyKind{bookStore, "book", func(names ...string) error { return catalog.ReloadBooks(names...) }}
+)
+
+//Page returns the page showing the named file, e.g. "/song/amazing".
+func (k historyKind) Page(name string) string {
+	if k.Param == "book" {
+		return "/book/" + name + "/index"
+	}
+
+	return "/" + k.Param + "/" + name
+}
+
+//HistoryPage lists the Revisions of a file, newest first, or the files
+//that have been deleted.
+type HistoryPage struct {
+	Kind      historyKind
+	Name      string
+	Revisions []Revision
+	Deleted   []HistoryLink
+	IndexPage
+}
+
+//HistoryLink is a link to the history of a file.
+type HistoryLink struct {
+	Link string
+	Kind string
+	Name string
+}
+
+//RevisionPage shows a Revision of a file, and how it changed from another.
+type RevisionPage struct {
+	Kind     historyKind
+	Name     string
+	Revision Revision
+	Against  int
+	Diff     []DiffLine
+	IndexPage
+}
+
+//registerHistory adds the pages showing and restoring the history of the
+//songs and songbooks.
+func registerHistory(r *httprouter.Router) {
+	r.GET("/history", deletedHandler)
+
+	for _, k := range []historyKind{songHistory, bookHistory} {
+		r.GET("/"+k.Param+"/:"+k.Param+"/history", historyHandler(k))
+		r.GET("/"+k.Param+"/:"+k.Param+"/history/:rev", revisionHandler(k))
+		r.POST("/"+k.Param+"/:"+k.Param+"/history/:rev/restore", writable(requireRole(RoleEditor, restoreHandler(k))))
+	}
+}
+
+func executeHistoryTemplate(w http.ResponseWriter, name string, data interface{}) {
+	t, err := template.ParseFiles(templateFiles(
+		"index.tmpl",
+		name)...)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := t.ExecuteTemplate(w, name, data); err != nil {
+		log.Println(err)
+	}
+}
+
+// deletedHandler is an HTTP handler that lists the deleted songs and
+// songbooks, which can be restored from their history.
+func deletedHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	data := HistoryPage{IndexPage: getBasicIndexData(r)}
+	data.Title = "Deleted Songs and Songbooks"
+
+	for _, k := range []historyKind{songHistory, bookHistory} {
+		names, err := history.Deleted(k.Store)
+		if err != nil {
+			log.Println(err)
+			data.Error = "The history could not be read."
+		}
+
+		for _, n := range names {
+			data.Deleted = append(data.Deleted, HistoryLink{"/" + k.Param + "/" + n + "/history", k.Store.Kind, n})
+		}
+	}
+
+	executeHistoryTemplate(w, "history.tmpl", data)
+}
+
+// historyHandler returns an HTTP handler that lists the revisions of a
+// song or songbook.
+func historyHandler(k historyKind) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		name := p.ByName(k.Param)
+		revs, err := history.Revisions(k.Store, name)
+		if err != nil {
+			storeHTTPError(w, err)
+			return
+		}
+
+		//newest first
+		for i, j := 0, len(revs)-1; i < j; i, j = i+1, j-1 {
+			revs[i], revs[j] = revs[j], revs[i]
+		}
+
+		data := HistoryPage{Kind: k, Name: name, Revisions: revs, IndexPage: getBasicIndexData(r)}
+		data.Title = "History of " + name
+
+		executeHistoryTemplate(w, "history.tmpl", data)
+	}
+}
+
+// revisionHandler returns an HTTP handler that shows a revision of a song
+// or songbook, and how it changed from the revision "against" (by default
+// the one before).
+func revisionHandler(k historyKind) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		name := p.ByName(k.Param)
+		rev, against, diff, err := revisionDiff(k.Store, name, p.ByName("rev"), r.FormValue("against"))
+		if err != nil {
+			storeHTTPError(w, err)
+			return
+		}
+
+		data := RevisionPage{Kind: k, Name: name, Revision: rev, Against: against, Diff: diff, IndexPage: getBasicIndexData(r)}
+		data.Title = fmt.Sprintf("%s, revision %d", name, rev.Number)
+
+		executeHistoryTemplate(w, "revision.tmpl", data)
+	}
+}
+
+// restoreHandler returns an HTTP handler that saves a revision of a song or
+// songbook as its current version, bringing it back if it was deleted.
+func restoreHandler(k historyKind) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		name := p.ByName(k.Param)
+		rev, err := restorableRevision(k.Store, name, p.ByName("rev"))
+		if err != nil {
+			storeHTTPError(w, err)
+			return
+		}
+
+		change := requestChange(r, r.PostFormValue("message"), fmt.Sprintf("Restored revision %d", rev.Number))
+		if err := k.Store.Save(name, []byte(rev.Content), change); err != nil {
+			storeHTTPError(w, err)
+			return
+		}
+
+		if err := k.Reload(name); err != nil {
+			log.Println(err)
+		}
+
+		http.Redirect(w, r, k.Page(name), http.StatusSeeOther)
+	}
+}
+
+//findRevision returns the Revision numbered <number> (a string, from a URL)
+//of the named file.
+func findRevision(s fileStore, name string, number string) (Revision, error) {
+	if history == nil {
+		return Revision{}, &StoreError{"find", s.Kind + " history", name, os.ErrNotExist}
+	}
+
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return Revision{}, &StoreError{"find", s.Kind + " revision", name + "@" + number, os.ErrNotExist}
+	}
+
+	return history.Revision(s, name, n)
+}
+
+//restorableRevision returns the Revision numbered <number> of the named
+//file, or an error if it cannot be restored, i.e. it is a delete.
+func restorableRevision(s fileStore, name string, number string) (Revision, error) {
+	rev, err := findRevision(s, name, number)
+	if err == nil && rev.Deleted {
+		err = &StoreError{"restore", s.Kind, name, ErrDeletedRevision}
+	}
+
+	return rev, err
+}
+
+//revisionDiff returns the Revision numbered <number> of the named file, and
+//the diff to it from the revision numbered <against> (or the one before it
+//if <against> is empty), along with that revision's number (or 0 for the
+//first revision, which is compared with an empty file).
+func revisionDiff(s fileStore, name string, number string, against string) (Revision, int, []DiffLine, error) {
+	rev, err := findRevision(s, name, number)
+	if err != nil {
+		return rev, 0, nil, err
+	}
+
+	if len(against) == 0 {
+		against = strconv.Itoa(rev.Number - 1)
+	}
+
+	from := Revision{}
+	if against != "0" {
+		if from, err = findRevision(s, name, against); err != nil {
+			return rev, 0, nil, err
+		}
+	}
+
+	return rev, from.Number, diffLines(from.Content, rev.Content), nil
+}
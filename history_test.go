@@ -0,0 +1,208 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var diffLinesTests = []struct {
+	from     string
+	to       string
+	expected string
+}{
+	{"", "", ""},
+	{"", "a\nb\n", "+a +b"},
+	{"a\nb\n", "", "-a -b"},
+	{"a\nb\nc\n", "a\nb\nc", " a  b  c"},
+	{"a\nb\nc\n", "a\nx\nc\n", " a -b +x  c"},
+	{"a\r\nb\r\n", "a\nb\nc\n", " a  b +c"},
+	{"{title: A}\nLine\n", "{title: B}\n\nLine\n", "-{title: A} +{title: B} +  Line"},
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, dt := range diffLinesTests {
+		lines := make([]string, 0)
+		for _, l := range diffLines(dt.from, dt.to) {
+			lines = append(lines, l.Op+l.Text)
+		}
+
+		if actual := strings.Join(lines, " "); actual != dt.expected {
+			t.Errorf("diffLines(%q, %q): expected %q, actual %q", dt.from, dt.to, dt.expected, actual)
+		}
+	}
+}
+
+//newTestHistory keeps the history in <dir>, returning a function that
+//stops keeping it again.
+func newTestHistory(dir string) func() {
+	history = NewHistory(filepath.Join(dir, "history"))
+	return func() { history = nil }
+}
+
+func TestHistory(t *testing.T) {
+	s, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+
+	//a song from before the history was kept
+	if err := s.Create("a", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+
+	defer newTestHistory(dir)()
+
+	if err := s.Save("a", []byte("two"), Change{"ann", "Second"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveNew("a", []byte("three"), Change{"ann", "Again"}); storeStatus(err) != http.StatusConflict {
+		t.Errorf("expected creating an existing song to conflict, actual %v", err)
+	}
+	if err := s.Delete("a", Change{"bob", "Gone"}); err != nil {
+		t.Fatal(err)
+	}
+
+	revs, err := history.Revisions(s, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := make([]Revision, len(revs))
+	for i, rev := range revs {
+		actual[i] = Revision{Number: rev.Number, Author: rev.Author, Message: rev.Message, Deleted: rev.Deleted, Content: rev.Content}
+	}
+	expected := []Revision{
+		{Number: 1, Message: "Before the history was kept", Content: "one"},
+		{Number: 2, Author: "ann", Message: "Second", Content: "two"},
+		{Number: 3, Author: "bob", Message: "Gone", Deleted: true},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected revisions %v, actual %v", expected, actual)
+	}
+
+	if deleted, err := history.Deleted(s); err != nil || !reflect.DeepEqual(deleted, []string{"a"}) {
+		t.Errorf("expected a to be deleted, actual %v (%v)", deleted, err)
+	}
+
+	if _, err := history.Revision(s, "a", 4); storeStatus(err) != http.StatusNotFound {
+		t.Errorf("expected revision 4 not found, actual %v", err)
+	}
+	if _, err := restorableRevision(s, "a", "3"); storeStatus(err) != http.StatusBadRequest {
+		t.Errorf("expected a delete not to be restorable, actual %v", err)
+	}
+	if _, err := history.Revisions(s, "../secret"); storeStatus(err) != http.StatusBadRequest {
+		t.Errorf("expected an invalid name, actual %v", err)
+	}
+
+	//a new file has no revision from before the history was kept
+	if err := s.SaveNew("b", []byte("new"), Change{"ann", "Created"}); err != nil {
+		t.Fatal(err)
+	}
+	if revs, err := history.Revisions(s, "b"); err != nil || len(revs) != 1 || revs[0].Content != "new" {
+		t.Errorf("expected one revision of b, actual %v (%v)", revs, err)
+	}
+}
+
+func TestRestoreHandler(t *testing.T) {
+	c, dir := newTestCatalog(t, map[string]string{"a.song": "{title: Alpha}\nLine\n"}, nil)
+	defer os.RemoveAll(dir)
+
+	oldCatalog, oldSongs, oldBooks := catalog, songs_root, books_root
+	catalog, songs_root, books_root = c, filepath.Join(dir, "songs"), filepath.Join(dir, "books")
+	defer func() {
+		catalog, songs_root, books_root = oldCatalog, oldSongs, oldBooks
+	}()
+	defer newTestHistory(dir)()
+
+	if err := songStore.Save("a", []byte("{title: Changed}\nLine\n"), Change{"ann", "Oops"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httprouter.New()
+	registerHistory(r)
+
+	for _, rt := range []struct {
+		url    string
+		status int
+	}{
+		{"/song/a/history/3/restore", http.StatusNotFound},
+		{"/song/a/history/x/restore", http.StatusNotFound},
+		{"/song/missing/history/1/restore", http.StatusNotFound},
+		{"/song/a/history/1/restore", http.StatusSeeOther},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("POST", rt.url, nil))
+
+		if rec.Code != rt.status {
+			t.Errorf("POST %s: expected %d, actual %d (%s)", rt.url, rt.status, rec.Code, rec.Body)
+		}
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "songs", "a.song")); err != nil || string(b) != "{title: Alpha}\nLine\n" {
+		t.Errorf("expected the song to be restored, actual %q (%v)", b, err)
+	}
+	if song, err := c.Song("a"); err != nil || song.Title != "Alpha" {
+		t.Errorf("expected the restored song to be reloaded, actual %v (%v)", song, err)
+	}
+	if rev, err := history.Revision(songStore, "a", 3); err != nil || rev.Message != "Restored revision 1" || rev.Author != "192.0.2.1" {
+		t.Errorf("expected the restore to be recorded, actual %v (%v)", rev, err)
+	}
+}
+
+var historyAPITests = []struct {
+	method   string
+	url      string
+	body     string
+	status   int
+	expected string
+}{
+	{"GET", "/api/v1/songs/a/history", "", 200, `[]`},
+	{"PUT", "/api/v1/songs/a", `{"content":"{title: Alpha}\n{key: G}\n[G]Amazing [D]grace!\n","message":"Punctuation"}`, 200, `"title":"Alpha"`},
+	{"GET", "/api/v1/songs/a/history", "", 200, `"author":"192.0.2.1","message":"Punctuation"}]`},
+	{"GET", "/api/v1/songs/a/history/1", "", 200, `"content":"{title: Alpha}\n{key: G}\n[G]Amazing [D]grace\n"`},
+	{"GET", "/api/v1/songs/a/history/3", "", 404, `song revision \"a@3\" not found`},
+	{"GET", "/api/v1/songs/a/diff", "", 200, `{"from":1,"to":2,"lines":[{"op":" ","text":"{title: Alpha}"},{"op":" ","text":"{key: G}"},{"op":"-","text":"[G]Amazing [D]grace"},{"op":"+","text":"[G]Amazing [D]grace!"}]}`},
+	{"GET", "/api/v1/songs/a/diff?from=2&to=1", "", 200, `{"op":"-","text":"[G]Amazing [D]grace!"},{"op":"+","text":"[G]Amazing [D]grace"}`},
+	{"GET", "/api/v1/songs/a/diff?from=0&to=1", "", 200, `{"from":0,"to":1`},
+	{"GET", "/api/v1/songs/..%5Csecret/history", "", 400, `invalid song name`},
+	{"DELETE", "/api/v1/books/book", "", 204, ""},
+	{"GET", "/api/v1/deleted", "", 200, `{"books":["book"],"songs":[]}`},
+	{"POST", "/api/v1/books/book/history/2/restore", "", 400, `cannot restore songbook \"book\": the revision is a delete`},
+	{"POST", "/api/v1/books/book/history/1/restore", "", 200, `"title":"The Book"`},
+	{"GET", "/api/v1/books/book/history", "", 200, `"message":"Restored revision 1"`},
+	{"GET", "/api/v1/deleted", "", 200, `{"books":[],"songs":[]}`},
+}
+
+func TestHistoryAPI(t *testing.T) {
+	h, done := newTestAPI(t)
+	defer done()
+	defer newTestHistory(filepath.Dir(songs_root))()
+
+	for _, at := range historyAPITests {
+		req := httptest.NewRequest(at.method, at.url, strings.NewReader(at.body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != at.status {
+			t.Errorf("%s %s: expected status %d, actual %d (%s)", at.method, at.url, at.status, rec.Code, rec.Body)
+		}
+
+		if !strings.Contains(rec.Body.String(), at.expected) {
+			t.Errorf("%s %s: expected %s in %s", at.method, at.url, at.expected, rec.Body)
+		}
+	}
+
+	//without a history, there is none to see
+	history = nil
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/songs/a/history", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected no history, actual %d (%s)", rec.Code, rec.Body)
+	}
+}
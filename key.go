@@ -0,0 +1,288 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+//Key represents a musical key, i.e. the tonic Note and whether it is minor.
+//Keys are used to spell transposed chords with the correct sharps or flats.
+//The zero Key represents an unknown key.
+type Key struct {
+	Tonic Note
+	Minor bool
+}
+
+var majorIntervals = []int{0, 2, 4, 5, 7, 9, 11}
+var minorIntervals = []int{0, 2, 3, 5, 7, 8, 10}
+
+//majorKeys and minorKeys are the conventional spelling of each key (C = 0).
+//F#/Gb and D#m/Ebm are equally common, these are chosen by Key.Transpose
+//depending on whether the original key used sharps or flats.
+var majorKeys = []Note{
+	{'C', 0},
+	{'D', -1},
+	{'D', 0},
+	{'E', -1},
+	{'E', 0},
+	{'F', 0},
+	{'F', 1},
+	{'G', 0},
+	{'A', -1},
+	{'A', 0},
+	{'B', -1},
+	{'B', 0},
+}
+
+var minorKeys = []Note{
+	{'C', 0},
+	{'C', 1},
+	{'D', 0},
+	{'D', 1},
+	{'E', 0},
+	{'F', 0},
+	{'F', 1},
+	{'G', 0},
+	{'G', 1},
+	{'A', 0},
+	{'B', -1},
+	{'B', 0},
+}
+
+var sharpNotes = []Note{
+	{'C', 0},
+	{'C', 1},
+	{'D', 0},
+	{'D', 1},
+	{'E', 0},
+	{'F', 0},
+	{'F', 1},
+	{'G', 0},
+	{'G', 1},
+	{'A', 0},
+	{'A', 1},
+	{'B', 0},
+}
+
+var flatNotes = []Note{
+	{'C', 0},
+	{'D', -1},
+	{'D', 0},
+	{'E', -1},
+	{'E', 0},
+	{'F', 0},
+	{'G', -1},
+	{'G', 0},
+	{'A', -1},
+	{'A', 0},
+	{'B', -1},
+	{'B', 0},
+}
+
+//naturalNotes are used for chromatic notes in keys without any
+//sharps or flats (C and Am), these are the most commonly borrowed chords.
+var naturalNotes = []Note{
+	{'C', 0},
+	{'C', 1},
+	{'D', 0},
+	{'E', -1},
+	{'E', 0},
+	{'F', 0},
+	{'F', 1},
+	{'G', 0},
+	{'A', -1},
+	{'A', 0},
+	{'B', -1},
+	{'B', 0},
+}
+
+var keyModeRegex = regexp.MustCompile("^\\s*(m|min|minor|maj|major)?$")
+
+//ParseKey parses a key name such as "G", "Bb", "F#m" or "E minor".
+//The naming system is detected, so "H" and "Sol" are also accepted.
+//ok is false if the text is not a recognisable key.
+func ParseKey(text string) (key Key, ok bool) {
+	return parseKeyName(text, NamingDefault)
+}
+
+//parseKeyName parses a key name written in the given NamingSystem,
+//NamingDefault tries each naming system in turn.
+func parseKeyName(text string, naming NamingSystem) (key Key, ok bool) {
+	text = strings.TrimSpace(text)
+	if naming == NamingDefault {
+		for _, n := range detectNamings {
+			if key, ok = parseKeyName(text, n); ok {
+				return key, true
+			}
+		}
+
+		return Key{}, false
+	}
+
+	tonic, rest, ok := parseNoteName(text, naming)
+	if !ok {
+		return Key{}, false
+	}
+
+	m := keyModeRegex.FindStringSubmatch(rest)
+	if m == nil {
+		return Key{}, false
+	}
+
+	key.Tonic = tonic
+	key.Minor = strings.HasPrefix(m[1], "m") && !strings.HasPrefix(m[1], "maj")
+
+	return key, true
+}
+
+//IsValid returns true if this Key has a tonic, false otherwise.
+func (key Key) IsValid() bool {
+	return key.Tonic.IsValid()
+}
+
+//String returns the name of this Key, e.g. "Bb" or "F#m".
+func (key Key) String() string {
+	if !key.IsValid() {
+		return ""
+	}
+
+	if key.Minor {
+		return key.Tonic.String() + "m"
+	}
+
+	return key.Tonic.String()
+}
+
+//Transpose returns the Key <change> half-notes away from this one,
+//using the conventional spelling for the new key.
+func (key Key) Transpose(change int) Key {
+	if !key.IsValid() || change%12 == 0 {
+		return key
+	}
+
+	pitch := mod12(key.Tonic.Pitch() + change)
+	res := Key{Tonic: majorKeys[pitch], Minor: key.Minor}
+	if key.Minor {
+		res.Tonic = minorKeys[pitch]
+	}
+
+	//F#/Gb and D#m/Ebm, keep whichever matches the original key
+	if (pitch == 6 && !key.Minor) || (pitch == 3 && key.Minor) {
+		if key.UsesFlats() {
+			res.Tonic = flatNotes[pitch]
+		} else {
+			res.Tonic = sharpNotes[pitch]
+		}
+	}
+
+	return res
+}
+
+//UsesFlats returns true if the key signature of this Key contains flats.
+func (key Key) UsesFlats() bool {
+	for _, n := range key.Scale() {
+		if n.Accidental < 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+//UsesSharps returns true if the key signature of this Key contains sharps.
+func (key Key) UsesSharps() bool {
+	for _, n := range key.Scale() {
+		if n.Accidental > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Scale returns the seven notes of this Key's (natural) scale, starting
+//with the tonic. Each note uses the next letter, so the key of Gb contains Cb
+//and the key of F# contains E#.
+func (key Key) Scale() []Note {
+	if !key.IsValid() {
+		return nil
+	}
+
+	intervals := majorIntervals
+	if key.Minor {
+		intervals = minorIntervals
+	}
+
+	letters := "CDEFGAB"
+	start := strings.IndexByte(letters, key.Tonic.Letter)
+	scale := make([]Note, len(intervals))
+
+	for i, interval := range intervals {
+		letter := letters[(start+i)%len(letters)]
+		pitch := key.Tonic.Pitch() + interval
+
+		//accidental is the difference between the letter and the pitch,
+		//wrapped into the range -6..5
+		acc := mod12(pitch-letterPitches[letter]+6) - 6
+		scale[i] = Note{Letter: letter, Accidental: acc}
+	}
+
+	return scale
+}
+
+//Spell returns the name of the given pitch (C = 0) in this Key.
+//Notes in the scale use the scale's spelling, other notes use sharps or flats
+//to match the key signature. If the Key is unknown, a default spelling is used.
+func (key Key) Spell(pitch int) Note {
+	pitch = mod12(pitch)
+	if !key.IsValid() {
+		return defaultNotes[pitch]
+	}
+
+	for _, n := range key.Scale() {
+		if n.Pitch() == pitch {
+			return n
+		}
+	}
+
+	if key.UsesFlats() {
+		return flatNotes[pitch]
+	} else if key.UsesSharps() {
+		return sharpNotes[pitch]
+	}
+
+	return naturalNotes[pitch]
+}
+
+//majorDiatonicMinor and minorDiatonicMinor list, for each scale degree, whether the chord built on it
+//is minor. Diminished chords are counted as minor.
+var majorDiatonicMinor = []bool{false, true, true, false, false, true, true}
+var minorDiatonicMinor = []bool{true, true, false, true, true, false, false}
+
+//chordScore returns how well the given Chord fits this Key:
+//1 point if the root is in the scale, another if the chord is major/minor as
+//expected for that scale degree, and another 2 if it is the tonic chord.
+func (key Key) chordScore(chord Chord) int {
+	diatonicMinor := majorDiatonicMinor
+	if key.Minor {
+		diatonicMinor = minorDiatonicMinor
+	}
+
+	for i, n := range key.Scale() {
+		if n.Pitch() != chord.Root.Pitch() {
+			continue
+		}
+
+		score := 1
+		if diatonicMinor[i] == chord.IsMinor() {
+			score++
+			if i == 0 {
+				score += 2
+			}
+		}
+
+		return score
+	}
+
+	return 0
+}
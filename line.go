@@ -1,7 +1,6 @@
 package main
 
 import (
-	"strings"
 	"unicode/utf8"
 )
 
@@ -10,6 +9,8 @@ import (
 //A series of Chords that appear in this Line (may be empty)
 //And an EchoIndex, indicating the portion of the Line that is an echo
 // (so it can be printed differently, i.e. italic/lighter/...)
+//EchoIndex and the Chords' Positions count characters (runes) in the Text,
+//not bytes, so "ā" or "’" is one character.
 type Line struct {
 	Text      string
 	Chords    []Chord
@@ -35,7 +36,7 @@ func (line Line) PreEchoText() string {
 		return line.Text
 	}
 
-	return line.Text[0:line.EchoIndex]
+	return line.Text[0:runeIndex(line.Text, line.EchoIndex)]
 }
 
 //EchoText returns this Line's text from EchoIndex to the end of the Text if
@@ -45,7 +46,7 @@ func (line Line) EchoText() string {
 		return ""
 	}
 
-	return line.Text[line.EchoIndex:utf8.RuneCountInString(line.Text)]
+	return line.Text[runeIndex(line.Text, line.EchoIndex):]
 }
 
 //PreChordText returns the substring of Text that occurs before the given Chord
@@ -70,7 +71,7 @@ func (line Line) PreChordText(chord Chord) string {
 
 	//chord is the first chord
 	if ind < 0 {
-		return line.Text[0:chord.Position]
+		return line.Text[0:runeIndex(line.Text, chord.Position)]
 	}
 
 	pos := line.Chords[ind].Position + utf8.RuneCountInString(line.Chords[ind].GetText())
@@ -81,7 +82,21 @@ func (line Line) PreChordText(chord Chord) string {
 		return ""
 	}
 
-	return line.Text[pos:chord.Position]
+	return line.Text[runeIndex(line.Text, pos):runeIndex(line.Text, chord.Position)]
+}
+
+//runeIndex returns the index in <text> of the byte starting the character
+//(rune) at <pos>, or the length of <text> if <pos> is past its end.
+func runeIndex(text string, pos int) int {
+	n := 0
+	for i := range text {
+		if n >= pos {
+			return i
+		}
+		n++
+	}
+
+	return len(text)
 }
 
 //SplitLine splits a single Line into two lines.
@@ -102,8 +117,9 @@ func (line Line) SplitLine() []Line {
 	if line.HasEcho() && line.EchoIndex > 0 {
 		split = line.EchoIndex
 	} else {
-		center := utf8.RuneCountInString(line.Text) / 2
-		maxWindow := utf8.RuneCountInString(line.Text) / 4
+		text := []rune(line.Text)
+		center := len(text) / 2
+		maxWindow := len(text) / 4
 
 		if maxWindow < 1 {
 			maxWindow = 1
@@ -113,7 +129,7 @@ func (line Line) SplitLine() []Line {
 		window := 1
 		split = center
 		for center > 0 && window <= maxWindow {
-			split = strings.Index(line.Text[center-window:center+window], ",")
+			split = indexRune(text[center-window:center+window], ',')
 			if split > 0 {
 				break
 			}
@@ -124,7 +140,7 @@ func (line Line) SplitLine() []Line {
 		if split < 0 {
 			window = 1
 			for window <= maxWindow {
-				split = strings.Index(line.Text[center-window:center+window], " ")
+				split = indexRune(text[center-window:center+window], ' ')
 				if split > 0 {
 					break
 				}
@@ -138,7 +154,7 @@ func (line Line) SplitLine() []Line {
 			split += center - window + 1 //+1 so we don't include the comma/space on the next line
 		}
 
-		for line.Text[split] == ' ' || line.Text[split] == ',' {
+		for split < len(text) && (text[split] == ' ' || text[split] == ',') {
 			split++
 		}
 	}
@@ -152,9 +168,22 @@ func (line Line) SplitLine() []Line {
 	return line.splitLineAt(split)
 }
 
+//indexRune returns the index of the first <r> in <text>, or -1 if there is none.
+func indexRune(text []rune, r rune) int {
+	for i, c := range text {
+		if c == r {
+			return i
+		}
+	}
+
+	return -1
+}
+
+//splitLineAt splits the Line into two before the character (rune) at <split>.
 func (line Line) splitLineAt(split int) []Line {
-	text1 := line.Text[0:split]
-	text2 := line.Text[split:]
+	text1 := line.Text[0:runeIndex(line.Text, split)]
+	text2 := line.Text[len(text1):]
+	length1 := utf8.RuneCountInString(text1)
 
 	chords1 := make([]Chord, 0)
 	chords2 := make([]Chord, 0)
@@ -162,10 +191,11 @@ func (line Line) splitLineAt(split int) []Line {
 	//now split up the chords
 	if line.HasChords() {
 		for _, c := range line.Chords {
-			if c.Position <= utf8.RuneCountInString(text1) {
+			if c.Position <= length1 {
 				chords1 = append(chords1, c)
 			} else {
-				chords2 = append(chords2, Chord{c.GetText(), c.Position - utf8.RuneCountInString(text1), c.Transpose})
+				c.Position -= length1
+				chords2 = append(chords2, c)
 			}
 		}
 	}
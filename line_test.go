@@ -107,6 +107,22 @@ var preEchoTests = []struct {
 		"Test string with echo index = 32",
 		"",
 	},
+	{
+		Line{
+			Text:      "Whakaaria mai tōu rīpeka ‘ki au’",
+			EchoIndex: 25,
+		},
+		"Whakaaria mai tōu rīpeka ",
+		"‘ki au’",
+	},
+	{
+		Line{
+			Text:      "ā",
+			EchoIndex: 100,
+		},
+		"ā",
+		"",
+	},
 }
 
 func TestPreEchoText(t *testing.T) {
@@ -183,6 +199,27 @@ var preChordTests = []struct {
 		},
 		"",
 	},
+	{
+		Line{
+			Text:   "Tōku ‘reo’, tōku ohooho",
+			Chords: []Chord{Chord{Position: 5, text: "G"}, Chord{Position: 12, text: "D"}},
+		},
+		Chord{
+			Position: 12,
+			text:     "D",
+		},
+		"reo’, ",
+	},
+	{
+		Line{
+			Text:   "ā ē",
+			Chords: []Chord{Chord{Position: 2}, Chord{Position: 10}},
+		},
+		Chord{
+			Position: 10,
+		},
+		"ē",
+	},
 }
 
 func TestPreChordText(t *testing.T) {
@@ -272,6 +309,36 @@ var splitLineTests = []struct {
 		-1,
 		-1,
 	},
+	{
+		Line{
+			Text:      "Ō ‘Ihowā’ rā, tō mātou Atua",
+			EchoIndex: -1,
+		},
+		"Ō ‘Ihowā’ rā, ",
+		"tō mātou Atua",
+		-1,
+		-1,
+	},
+	{
+		Line{
+			Text:      "Āāāāāāāāāāāāāāāāāāāā",
+			EchoIndex: -1,
+		},
+		"Āāāāāāāāāā",
+		"āāāāāāāāāā",
+		-1,
+		-1,
+	},
+	{
+		Line{
+			Text:      "Kia tau te rangimārie ki a tātou",
+			EchoIndex: 22,
+		},
+		"Kia tau te rangimārie ",
+		"ki a tātou",
+		-1,
+		0,
+	},
 }
 
 func TestSplitLine(t *testing.T) {
@@ -314,6 +381,15 @@ var splitLineChordTests = []struct {
 		[]Chord{Chord{text: "A", Position: 5, Transpose: 0}},
 		[]Chord{Chord{text: "B", Position: 8, Transpose: 0}},
 	},
+	{
+		Line{
+			Text:      "Ō ‘Ihowā’ rā, tō mātou Atua",
+			Chords:    []Chord{Chord{text: "A", Position: 3, Transpose: 0}, Chord{text: "B", Position: 17, Transpose: 0}},
+			EchoIndex: -1,
+		},
+		[]Chord{Chord{text: "A", Position: 3, Transpose: 0}},
+		[]Chord{Chord{text: "B", Position: 3, Transpose: 0}},
+	},
 }
 
 // text      string
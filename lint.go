@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//LintFile is a song file to be checked, along with the Song read from it
+//and any problems found when reading it.
+type LintFile struct {
+	Name        string
+	Source      []byte
+	Song        *Song
+	Diagnostics []Diagnostic
+}
+
+//LintContext is everything a LintRule may compare a song with:
+//every known song (including the files being checked), and the songbooks.
+type LintContext struct {
+	Library []LintFile
+	Books   []*Songbook
+}
+
+//LintRule checks songs for one kind of authoring mistake.
+//Check returns the problems found in <files>.
+type LintRule struct {
+	Name        string
+	Description string
+	Check       func(files []LintFile, ctx LintContext) []Diagnostic
+}
+
+//LintRules lists all rules used by Lint.
+var LintRules = []LintRule{
+	{"unterminated-section", "A {start_of_chorus} (or verse, bridge, tab or grid) that is never ended", eachFile(lintUnterminatedSections)},
+	{"unmatched-end", "An {end_of_chorus} (or verse, bridge, tab or grid) without a start", eachFile(lintUnmatchedEnds)},
+	{"unknown-chord", "Chords that are not recognised, so cannot be transposed", eachFile(lintChords)},
+	{"echo", "More than one echo tag on a line, or text after an echo tag", eachFile(lintEchoes)},
+	{"duplicate-title", "Songs with the same title as another song", lintDuplicateTitles},
+	{"missing-section", "Songs without a {section} in a songbook that uses {index_use_sections}", lintMissingSections},
+	{"font-charset", "Characters the PDF font cannot show", eachFile(lintCharset)},
+}
+
+//NewLintFile reads the Song from the given file contents, ready for linting.
+func NewLintFile(name string, src []byte) LintFile {
+	song, diags, _ := ParseSong(bytes.NewReader(src), ParseOptions{Filename: name})
+
+	return LintFile{Name: name, Source: src, Song: song, Diagnostics: diags}
+}
+
+//Lint checks the given files with every LintRule, returning the problems
+//found along with any found when reading the files, in file and line order.
+func Lint(files []LintFile, ctx LintContext) []Diagnostic {
+	diags := make([]Diagnostic, 0)
+	for _, f := range files {
+		for _, d := range f.Diagnostics {
+			d.Rule = "parse"
+			diags = append(diags, d)
+		}
+	}
+
+	for _, rule := range LintRules {
+		for _, d := range rule.Check(files, ctx) {
+			d.Rule = rule.Name
+			diags = append(diags, d)
+		}
+	}
+
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i], diags[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	return diags
+}
+
+//eachFile makes a LintRule check that checks each file on its own.
+func eachFile(check func(file LintFile) []Diagnostic) func([]LintFile, LintContext) []Diagnostic {
+	return func(files []LintFile, ctx LintContext) []Diagnostic {
+		diags := make([]Diagnostic, 0)
+		for _, f := range files {
+			diags = append(diags, check(f)...)
+		}
+
+		return diags
+	}
+}
+
+//sourceLine is a single line of a song file.
+type sourceLine struct {
+	number int
+	text   string
+	//directive name and value, if the line is a directive
+	directive string
+	value     string
+	//true for lines in a tab or grid section
+	verbatim bool
+}
+
+//sourceLines splits the file into lines, in the same way as ParseSong.
+func (file LintFile) sourceLines() []sourceLine {
+	lines := make([]sourceLine, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(file.Source))
+	scanner.Split(scanSongLines)
+	verbatim := false
+
+	for n := 1; scanner.Scan(); n++ {
+		l := sourceLine{number: n, text: scanner.Text()}
+		if strings.HasPrefix(l.text, "{") && !strings.HasPrefix(strings.ToLower(l.text), "{echo") {
+			l.directive, l.value = parseDirective(l.text)
+			switch l.directive {
+			case "start_of_tab", "start_of_grid":
+				verbatim = true
+			case "end_of_tab", "end_of_grid":
+				verbatim = false
+			}
+		} else {
+			l.verbatim = verbatim
+		}
+
+		lines = append(lines, l)
+	}
+
+	return lines
+}
+
+func (file LintFile) diagnostic(line int, column int, severity Severity, format string, args ...interface{}) Diagnostic {
+	return Diagnostic{
+		File:     file.Name,
+		Line:     line,
+		Column:   column,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...)}
+}
+
+//sectionName returns the section started or ended by the given directive,
+//e.g. "chorus" for "start_of_chorus", and whether it starts the section.
+func sectionName(directive string) (section string, start bool, ok bool) {
+	if strings.HasPrefix(directive, "start_of_") {
+		section = strings.TrimPrefix(directive, "start_of_")
+		start = true
+	} else if strings.HasPrefix(directive, "end_of_") {
+		section = strings.TrimPrefix(directive, "end_of_")
+	}
+
+	_, ok = sectionKinds[section]
+	return section, start, ok
+}
+
+func lintUnterminatedSections(file LintFile) []Diagnostic {
+	diags := make([]Diagnostic, 0)
+	open, openLine := "", 0
+
+	for _, l := range file.sourceLines() {
+		section, start, ok := sectionName(l.directive)
+		if !ok {
+			continue
+		}
+
+		if start {
+			if len(open) > 0 {
+				diags = append(diags, file.diagnostic(openLine, 0, SeverityWarning,
+					"{start_of_%s} is not ended before {%s}", open, l.directive))
+			}
+			open, openLine = section, l.number
+		} else if section == open {
+			open = ""
+		}
+	}
+
+	if len(open) > 0 {
+		diags = append(diags, file.diagnostic(openLine, 0, SeverityWarning,
+			"{start_of_%s} is never ended", open))
+	}
+
+	return diags
+}
+
+func lintUnmatchedEnds(file LintFile) []Diagnostic {
+	diags := make([]Diagnostic, 0)
+	open := ""
+
+	for _, l := range file.sourceLines() {
+		section, start, ok := sectionName(l.directive)
+		if !ok {
+			continue
+		}
+
+		if start {
+			open = section
+		} else if section != open {
+			diags = append(diags, file.diagnostic(l.number, 0, SeverityWarning,
+				"{%s} without {start_of_%s}", l.directive, section))
+		} else {
+			open = ""
+		}
+	}
+
+	return diags
+}
+
+//chordMarks are chord texts that are not chords, but are still expected.
+var chordMarks = map[string]bool{
+	"N.C.": true,
+	"N.C":  true,
+	"NC":   true,
+	"x":    true,
+	"%":    true,
+	"/":    true,
+}
+
+func lintChords(file LintFile) []Diagnostic {
+	diags := make([]Diagnostic, 0)
+	chordRegex := regexp.MustCompile("\\[.*?\\]")
+
+	for _, l := range file.sourceLines() {
+		if len(l.directive) > 0 || l.verbatim {
+			continue
+		}
+
+		for _, pos := range chordRegex.FindAllStringIndex(l.text, -1) {
+			text := strings.TrimSpace(l.text[pos[0]+1 : pos[1]-1])
+			if chordMarks[text] {
+				continue
+			}
+
+			for _, token := range chordTokenRegex.FindAllString(text, -1) {
+				if _, ok := parseChordName(token, file.Song.Naming); !ok && !chordMarks[token] {
+					diags = append(diags, file.diagnostic(l.number, lineColumn(l.text, pos[0]), SeverityWarning,
+						"unknown chord %q", token))
+				}
+			}
+		}
+	}
+
+	return diags
+}
+
+func lintEchoes(file LintFile) []Diagnostic {
+	diags := make([]Diagnostic, 0)
+
+	for _, l := range file.sourceLines() {
+		if len(l.directive) > 0 || l.verbatim {
+			continue
+		}
+
+		i := strings.Index(l.text, "{echo:")
+		if i < 0 {
+			continue
+		}
+
+		if j := strings.Index(l.text[i+1:], "{echo:"); j >= 0 {
+			diags = append(diags, file.diagnostic(l.number, lineColumn(l.text, i+1+j), SeverityError,
+				"more than one echo tag on a line"))
+		}
+
+		end := strings.Index(l.text[i:], "}")
+		if end >= 0 && len(strings.TrimSpace(l.text[i+end+1:])) > 0 {
+			diags = append(diags, file.diagnostic(l.number, lineColumn(l.text, i+end+1), SeverityError,
+				"text after the echo tag is not shown"))
+		}
+	}
+
+	return diags
+}
+
+func lintDuplicateTitles(files []LintFile, ctx LintContext) []Diagnostic {
+	diags := make([]Diagnostic, 0)
+
+	for _, f := range files {
+		for _, other := range ctx.Library {
+			if sameFile(f.Name, other.Name) || f.Song == nil || other.Song == nil {
+				continue
+			}
+
+			if strings.EqualFold(strings.TrimSpace(f.Song.Title), strings.TrimSpace(other.Song.Title)) {
+				diags = append(diags, f.diagnostic(0, 0, SeverityWarning,
+					"title %q is also used by %s", f.Song.Title, filepath.Base(other.Name)))
+			}
+		}
+	}
+
+	return diags
+}
+
+func lintMissingSections(files []LintFile, ctx LintContext) []Diagnostic {
+	diags := make([]Diagnostic, 0)
+
+	for _, f := range files {
+		if f.Song == nil || len(f.Song.Section) > 0 {
+			continue
+		}
+
+		for _, book := range ctx.Books {
+			if !book.UseSection {
+				continue
+			}
+
+			for _, song := range book.Songs {
+				if song.Filename == filepath.Base(f.Name) {
+					diags = append(diags, f.diagnostic(0, 0, SeverityWarning,
+						"no {section}, but songbook %q uses {index_use_sections}", book.Title))
+					break
+				}
+			}
+		}
+	}
+
+	return diags
+}
+
+func lintCharset(file LintFile) []Diagnostic {
+	diags := make([]Diagnostic, 0)
+
+	font := "the PDF font"
+	charset := cp1252Charset()
+	if file.Song != nil && file.Song.UseLiberationFont {
+		font = "the Liberation Serif font (used as the song contains ā)"
+		charset = iso88594Charset()
+		if charset == nil {
+			return diags
+		}
+	}
+
+	for _, l := range file.sourceLines() {
+		for i, r := range l.text {
+			if r == '\t' || charset[r] {
+				continue
+			}
+
+			diags = append(diags, file.diagnostic(l.number, lineColumn(l.text, i), SeverityWarning,
+				"%q cannot be shown by %s", r, font))
+			break
+		}
+	}
+
+	return diags
+}
+
+//cp1252Extra are the characters of Windows-1252 (the PDF fonts' character
+//set) that are not in Latin-1.
+const cp1252Extra = "€‚ƒ„…†‡ˆ‰Š‹ŒŽ‘’“”•–—˜™š›œžŸ"
+
+var (
+	cp1252Once  sync.Once
+	cp1252Set   map[rune]bool
+	iso8859Once sync.Once
+	iso8859Set  map[rune]bool
+)
+
+func cp1252Charset() map[rune]bool {
+	cp1252Once.Do(func() {
+		cp1252Set = make(map[rune]bool)
+		for r := rune(0x20); r <= 0xFF; r++ {
+			if r < 0x7F || r >= 0xA0 {
+				cp1252Set[r] = true
+			}
+		}
+		for _, r := range cp1252Extra {
+			cp1252Set[r] = true
+		}
+	})
+
+	return cp1252Set
+}
+
+//iso88594Charset returns the characters in the iso-8859-4.map font map,
+//nil if it cannot be read.
+func iso88594Charset() map[rune]bool {
+	iso8859Once.Do(func() {
+		data, err := ioutil.ReadFile(filepath.Join(pdfFontDir, "iso-8859-4.map"))
+		if err != nil {
+			return
+		}
+
+		iso8859Set = make(map[rune]bool)
+		for _, line := range strings.Split(string(data), "\n") {
+			//e.g. "!E0 U+0101 amacron"
+			fields := strings.Fields(line)
+			if len(fields) < 3 || fields[2] == ".notdef" || !strings.HasPrefix(fields[1], "U+") {
+				continue
+			}
+
+			if r, err := strconv.ParseInt(fields[1][2:], 16, 32); err == nil && r >= 0x20 {
+				iso8859Set[rune(r)] = true
+			}
+		}
+	})
+
+	return iso8859Set
+}
+
+//sameFile returns true if the two paths name the same file.
+func sameFile(a string, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+
+	return absA == absB
+}
+
+//loadLintFiles reads each of the given .song files.
+func loadLintFiles(names []string) ([]LintFile, error) {
+	files := make([]LintFile, 0)
+	for _, name := range names {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, NewLintFile(name, src))
+	}
+
+	return files, nil
+}
+
+//loadLintContext reads every song and songbook, for comparing songs with.
+func loadLintContext() LintContext {
+	ctx := LintContext{}
+
+	if names, err := songFiles([]string{songs_root}); err == nil {
+		ctx.Library, _ = loadLintFiles(names)
+	}
+
+	books, _ := filepath.Glob(filepath.Join(books_root, "*.songlist"))
+	for _, b := range books {
+		if book, err := ParseSongbookFile(b, songs_root); err == nil {
+			ctx.Books = append(ctx.Books, book)
+		}
+	}
+
+	return ctx
+}
+
+//lintSongs checks the given .song files (or all songs in the given
+//directories, by default the songs directory), comparing them with every
+//song and songbook.
+func lintSongs(paths []string) ([]Diagnostic, error) {
+	if len(paths) == 0 {
+		paths = []string{songs_root}
+	}
+
+	names, err := songFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := loadLintFiles(names)
+	if err != nil {
+		return nil, err
+	}
+
+	//the files being checked are in the library, unless they are not in the songs directory
+	ctx := loadLintContext()
+	for _, f := range files {
+		if !inLibrary(ctx.Library, f.Name) {
+			ctx.Library = append(ctx.Library, f)
+		}
+	}
+
+	return Lint(files, ctx), nil
+}
+
+//inLibrary returns true if the named file is one of the library's files.
+func inLibrary(library []LintFile, name string) bool {
+	for _, f := range library {
+		if sameFile(f.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//runLint runs the "lint" command, which checks the given .song files (or all
+//songs in the given directories, by default the songs directory).
+//Problems are listed as text, or as JSON with -json.
+//Returns the exit code, 1 if any errors were found.
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "list problems as JSON")
+	cf := addConfigFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: isb lint [-json] [file.song|directory ...]")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "rules:")
+		for _, r := range LintRules {
+			fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", r.Name, r.Description)
+		}
+	}
+	flags.Parse(args)
+
+	if err := cf.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	diags, err := lintSongs(flags.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(diags, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		for _, d := range diags {
+			fmt.Println(d)
+		}
+	}
+
+	if HasErrors(diags) {
+		return 1
+	}
+
+	return 0
+}
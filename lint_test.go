@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var lintTests = []struct {
+	in       string
+	expected []string
+}{
+	{"{title: Fine}\n\n{soc}\n[G]Line {echo: [D]line}\n{eoc}\n", []string{}},
+	{"{soc}\nA line\n\n{soc}\nB line\n", []string{
+		"test.song:1: warning: {start_of_chorus} is not ended before {start_of_chorus} [unterminated-section]",
+		"test.song:4: warning: {start_of_chorus} is never ended [unterminated-section]"}},
+	{"A line\n{eoc}\n", []string{"test.song:2: warning: {end_of_chorus} without {start_of_chorus} [unmatched-end]"}},
+	{"[G]A [Xyz]line [N.C.]\n", []string{"test.song:1:6: warning: unknown chord \"Xyz\" [unknown-chord]"}},
+	{"{sot}\n[Xyz] tab\n{eot}\n", []string{}},
+	{"A {echo: b} c\n", []string{"test.song:1:12: error: text after the echo tag is not shown [echo]"}},
+	{"A {echo: b}{echo: c}\n", []string{
+		"test.song:1:12: error: unexpected \"{\" [parse]",
+		"test.song:1:12: error: more than one echo tag on a line [echo]",
+		"test.song:1:12: error: text after the echo tag is not shown [echo]",
+		"test.song:1:20: error: unexpected \"}\" [parse]"}},
+	{"Ein Lied ⅓\n", []string{"test.song:1:10: warning: '⅓' cannot be shown by the PDF font [font-charset]"}},
+}
+
+func TestLintRules(t *testing.T) {
+	for _, lt := range lintTests {
+		files := []LintFile{NewLintFile("test.song", []byte(lt.in))}
+		diags := Lint(files, LintContext{Library: files})
+
+		if len(diags) != len(lt.expected) {
+			t.Errorf("%q: expected %v, actual %v", lt.in, lt.expected, diags)
+			continue
+		}
+
+		for i, d := range diags {
+			if d.String() != lt.expected[i] {
+				t.Errorf("%q: expected %s, actual %s", lt.in, lt.expected[i], d)
+			}
+		}
+	}
+}
+
+func TestLintLibrary(t *testing.T) {
+	a := NewLintFile("a.song", []byte("{title: Same}\nA line\n"))
+	b := NewLintFile("b.song", []byte("{title: same}\nB line\n"))
+	book := &Songbook{Title: "Book", UseSection: true, Songs: map[int]Song{1: *a.Song}}
+	a.Song.Filename = "a.song"
+	book.Songs[1] = *a.Song
+
+	diags := Lint([]LintFile{a}, LintContext{Library: []LintFile{a, b}, Books: []*Songbook{book}})
+	expected := []string{
+		"a.song: warning: title \"Same\" is also used by b.song [duplicate-title]",
+		"a.song: warning: no {section}, but songbook \"Book\" uses {index_use_sections} [missing-section]",
+	}
+
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %v, actual %v", expected, diags)
+	}
+	for i, d := range diags {
+		if d.String() != expected[i] {
+			t.Errorf("expected %s, actual %s", expected[i], d)
+		}
+	}
+}
+
+func TestLintSongs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "isb-lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldSongs, oldBooks := songs_root, books_root
+	songs_root, books_root = dir, dir
+	defer func() { songs_root, books_root = oldSongs, oldBooks }()
+
+	for _, name := range []string{"a.song", "b.song"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("{title: Same}\nA line\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := filepath.Join(dir, "other")
+	if err := os.Mkdir(other, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(other, "c.song"), []byte("{title: Same}\nC line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	//each song in the library is compared with the others once
+	for _, lt := range []struct {
+		paths    []string
+		expected int
+	}{
+		{nil, 2},
+		{[]string{filepath.Join(dir, "a.song")}, 1},
+		{[]string{filepath.Join(dir, ".", "a.song"), filepath.Join(dir, "b.song")}, 2},
+		{[]string{filepath.Join(other, "c.song")}, 2},
+	} {
+		diags, err := lintSongs(lt.paths)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(diags) != lt.expected {
+			t.Errorf("%v: expected %d duplicate titles, actual %v", lt.paths, lt.expected, diags)
+		}
+	}
+}
@@ -4,14 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 
@@ -23,76 +25,112 @@ var (
 	books_root = "./books"
 )
 
-func getSongFilename(file string) string {
-	return songs_root + "/" + file + ".song"
+func main() {
+	os.Exit(runCommand(os.Args[1:]))
 }
 
-func main() {
+//runServe runs the "serve" command, which serves the song book web site.
+func runServe(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	port := flags.Int("port", 0, "listen on `port`, short for -listen :port")
+	cf := addConfigFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: isb serve [-port 8090] [options]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
 
-	//Follow symlinks if applicable
-	link, _ := os.Readlink(songs_root)
-	if link != "" {
-		songs_root = link
+	if err := cf.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
 	}
-	link, _ = os.Readlink(books_root)
-	if link != "" {
-		books_root = link
+	if *port > 0 {
+		config.Listen = ":" + strconv.Itoa(*port)
 	}
 
 	//basic sanity check
 	_, err := os.Stat(songs_root)
 	if os.IsNotExist(err) {
 		fmt.Printf("Error: Songs path (%s) does not exist.\n", songs_root)
-		return
+		return 1
 	}
 
 	_, err = os.Stat(books_root)
 	if os.IsNotExist(err) {
 		fmt.Printf("Error: Books path (%s) does not exist.\n", books_root)
-		return
+		return 1
 	}
 
 	//Load songs and books
-	err = loadSongs(songs_root)
+	catalog = NewCatalog(songs_root, books_root)
+	err = catalog.Load()
 	if err != nil {
-		fmt.Println("Error loading songs")
+		fmt.Println("Error loading songs and books")
 		fmt.Println(err)
-		return
+		return 1
 	}
 
-	err = loadBooks(books_root)
-	if err != nil {
-		fmt.Println("Error loading books")
-		fmt.Println(err)
-		return
+	fmt.Printf("%d Books loaded.\n", len(catalog.Books()))
+	fmt.Printf("%d Songs loaded.\n", len(catalog.Songs()))
+
+	//Only let users who log in change songs and books
+	if len(config.UsersFile) > 0 {
+		users, err := loadUsersFile(config.UsersFile)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+
+		auth = NewAuth(users)
+		fmt.Printf("%d Users loaded.\n", len(users))
 	}
 
-	fmt.Printf("%d Songs loaded.\n", len(loadedSongs))
+	//Keep every version of the songs and books, so changes can be undone
+	if len(config.HistoryDir) > 0 {
+		history = NewHistory(config.HistoryDir)
+	}
 
-	//Sort lists by title
-	sort.Sort(ByTitle(loadedSongs))
-	sort.Sort(ByTitle(loadedBooks))
+	//Reload songs and books when their files change
+	if err := catalog.Watch(config.Watch, config.PollInterval, make(chan struct{})); err != nil {
+		fmt.Println(err)
+		return 1
+	}
 
 	r := httprouter.New()
 
 	r.GET("/", indexHandler)
 	r.GET("/index.php", indexHandler)
 	r.GET("/index.html", indexHandler)
+	r.GET("/search", searchHandler)
 	r.GET("/song/:song", songHandler)
-	r.GET("/song/:song/edit", editSongHandler)
+	r.GET("/songs/new", writable(requireRole(RoleEditor, newSongHandler)))
+	r.GET("/song/:song/edit", writable(requireRole(RoleEditor, editSongHandler)))
 	r.GET("/pdf/song/:song", songPdfHandler)
 	r.GET("/pdf/book/:book/version/:version", bookPdfHandler)
 	r.GET("/book/:book/index", bookIndexHandler)
-	r.GET("/book/:book/edit", editBookHandler)
+	r.GET("/book/:book/edit", writable(requireRole(RoleEditor, editBookHandler)))
 	r.GET("/book/:book/song/:number", bookHandler)
-	r.ServeFiles("/css/*filepath", http.Dir("css"))
-	r.ServeFiles("/js/*filepath", http.Dir("js"))
-
-	r.POST("/song/:song/edit", editSongPostHandler)
-	r.POST("/book/:book/edit", editBookPostHandler)
-	r.DELETE("/book/:book/edit", editBookDeleteHandler)
-	log.Fatal(http.ListenAndServe(":8090", r))
-
+	r.ServeFiles("/css/*filepath", http.Dir(filepath.Join(config.StaticDir, "css")))
+	r.ServeFiles("/js/*filepath", http.Dir(filepath.Join(config.StaticDir, "js")))
+
+	r.POST("/songs/new", writable(requireRole(RoleEditor, newSongPostHandler)))
+	r.POST("/song/:song/edit", writable(requireRole(RoleEditor, editSongPostHandler)))
+	r.POST("/song/:song/rename", writable(requireRole(RoleEditor, renameSongHandler)))
+	r.DELETE("/song/:song/edit", writable(requireRole(RoleAdmin, editSongDeleteHandler)))
+	r.POST("/song/:song/format", writable(requireRole(RoleEditor, formatSongHandler)))
+	r.POST("/song/:song/lint", writable(requireRole(RoleEditor, lintSongHandler)))
+	r.POST("/song/:song/preview", writable(requireRole(RoleEditor, previewSongHandler)))
+	r.POST("/book/:book/edit", writable(requireRole(RoleEditor, editBookPostHandler)))
+	r.DELETE("/book/:book/edit", writable(requireRole(RoleAdmin, editBookDeleteHandler)))
+	registerAPI(r)
+	if history != nil {
+		registerHistory(r)
+	}
+	if auth != nil {
+		registerAuth(r)
+	}
+	log.Println(http.ListenAndServe(config.Listen, logRequests(r)))
+	return 1
 }
 
 type DisplayList struct {
@@ -145,7 +183,13 @@ type IndexPage struct {
 	ShowIndigo   bool
 	SelectedSong string
 	SelectedBook string
+	Query        string
 	Error        string
+	HasAuth      bool
+	User         string
+	Role         Role
+	CSRFToken    string
+	HasHistory   bool
 }
 
 func (i IndexPage) HasSong() bool {
@@ -156,6 +200,28 @@ func (i IndexPage) HasBook() bool {
 	return len(i.SelectedBook) > 0
 }
 
+//CanEdit returns true if the user may create and change songs and songbooks.
+func (i IndexPage) CanEdit() bool {
+	return !config.ReadOnly && i.Role >= RoleEditor
+}
+
+//CanDelete returns true if the user may delete songs and songbooks.
+func (i IndexPage) CanDelete() bool {
+	return !config.ReadOnly && i.Role >= RoleAdmin
+}
+
+func (i IndexPage) Notations() []Notation {
+	return Notations
+}
+
+func (i IndexPage) Namings() []NamingSystem {
+	return Namings
+}
+
+func (i IndexPage) Instruments() []*Instrument {
+	return Instruments
+}
+
 type SongPage struct {
 	Song     Song
 	Songbook Songbook
@@ -168,28 +234,48 @@ type SongPage struct {
 type BookPage struct {
 	Songbook Songbook
 	Selected int
+	//Version is the Version of the songbook's file, when editing it
+	Version string
+	IndexPage
+}
+
+type SearchPage struct {
+	Results []SearchResult
+	IndexPage
+}
+
+type NewSongPage struct {
+	Name      string
+	SongTitle string
 	IndexPage
 }
 
 type EditSongPage struct {
 	Title   string
 	Content string
+	Version string
 	IndexPage
 }
 
-var loadedSongs = make([]DisplayList, 0)
-var loadedBooks = make([]DisplayList, 0)
-var recent = make([]DisplayList, 0)
-var last_err = ""
+//editConflict is the response to saving a song or songbook that someone
+//else has changed since its edit page was opened: their Content, its
+//Version (to save over it with) and the Diff from their content to the
+//one being saved.
+type editConflict struct {
+	Error   string     `json:"error"`
+	Content string     `json:"content"`
+	Version string     `json:"version"`
+	Diff    []DiffLine `json:"diff"`
+}
 
 // indexHandler is an HTTP handler that serves the index page.
 func indexHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	t, err := template.ParseFiles("templates/index.tmpl", "templates/_song_select.tmpl", "templates/_book_select.tmpl")
+	t, err := template.ParseFiles(templateFiles("index.tmpl", "_search_form.tmpl", "_song_select.tmpl", "_book_select.tmpl")...)
 	if err != nil {
 		panic(err)
 	}
 
-	data := getBasicIndexData()
+	data := getBasicIndexData(r)
 	data.ShowIndigo = true
 
 	if err := t.ExecuteTemplate(w, "index.tmpl", data); err != nil {
@@ -197,71 +283,96 @@ func indexHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	}
 }
 
-func loadSongFile(title string, transpose int) (*Song, error) {
-	filename := getSongFilename(title)
-
-	song, err := ParseSongFile(filename, transpose)
+//searchLimit is the most songs shown by a search.
+const searchLimit = 50
 
+// searchHandler is an HTTP handler that serves the songs matching the
+// query "q", searching their titles, lyrics, comments and details.
+func searchHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	t, err := template.ParseFiles(templateFiles(
+		"index.tmpl",
+		"_search_form.tmpl",
+		"search.tmpl")...)
 	if err != nil {
-		return nil, err
+		panic(err)
 	}
 
-	return song, nil
+	data := SearchPage{IndexPage: getBasicIndexData(r)}
+	data.Title = "Search"
+	data.Query = strings.TrimSpace(r.FormValue("q"))
+	data.Results = catalog.Search(data.Query, searchLimit)
+
+	if err := t.ExecuteTemplate(w, "search.tmpl", data); err != nil {
+		log.Println(err)
+	}
 }
 
-func getBasicIndexData() IndexPage {
+func getBasicIndexData(r *http.Request) IndexPage {
 	index_data := IndexPage{
 		Title:        "Indigo Song Book",
-		Recent:       recent,
-		Songs:        loadedSongs,
-		Books:        loadedBooks,
+		Recent:       catalog.Recent(),
+		Songs:        catalog.Songs(),
+		Books:        catalog.Books(),
 		ShowIndigo:   false,
 		SelectedSong: "",
 		SelectedBook: "",
-		Error:        last_err}
-
-	last_err = ""
+		Error:        catalog.TakeError(),
+		HasAuth:      auth != nil,
+		HasHistory:   history != nil,
+		Role:         RoleAdmin}
+
+	//without authentication everyone can do everything
+	if auth != nil {
+		index_data.Role = RoleViewer
+		if s := auth.Session(r); s != nil {
+			index_data.User = s.User
+			index_data.Role = s.Role
+			index_data.CSRFToken = s.CSRFToken
+		}
+	}
 
 	return index_data
 }
 
 func editBookDeleteHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	//find songbook file
-	name := strings.TrimSpace(p.ByName("book"))
-	err := os.Remove(books_root + "/" + name + ".songlist")
-
-	defer loadBooks(books_root)
-
-	if err != nil {
-		fmt.Println(err)
+	name := p.ByName("book")
+	if err := bookStore.Delete(name, requestChange(r, "", "Deleted")); err != nil {
+		storeHTTPError(w, err)
 		return
 	}
+
+	if err := catalog.ReloadBooks(name); err != nil {
+		log.Println(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func editBookPostHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	raw_settings := r.PostFormValue("settings")
 	var settings map[string]string
-	_ = json.Unmarshal([]byte(raw_settings), &settings)
+	if err := json.Unmarshal([]byte(raw_settings), &settings); err != nil {
+		http.Error(w, "invalid songbook settings", http.StatusBadRequest)
+		return
+	}
 
 	raw_songs := r.PostFormValue("songs")
 	var songs []string
-
-	_ = json.Unmarshal([]byte(raw_songs), &songs)
-
-	//open songbook file
-	name := strings.TrimSpace(settings["name"])
-	file, err := os.Create(books_root + "/" + name + ".songlist")
-
-	defer file.Close()
-	defer loadBooks(books_root)
-
-	if err != nil {
-		fmt.Println(err)
+	if err := json.Unmarshal([]byte(raw_songs), &songs); err != nil {
+		http.Error(w, "invalid songbook songs", http.StatusBadRequest)
 		return
 	}
 
+	var file bytes.Buffer
+
 	//write out the settings
 	for i, k := range settings {
+		//each setting is a single line
+		if strings.ContainsAny(k, "\r\n{}") {
+			http.Error(w, fmt.Sprintf("invalid value for %s: %q", i, k), http.StatusBadRequest)
+			return
+		}
+
 		switch i {
 		case "name":
 			continue
@@ -279,6 +390,13 @@ func editBookPostHandler(w http.ResponseWriter, r *http.Request, p httprouter.Pa
 		case "use-sections":
 			file.WriteString("{index_use_sections}\n")
 			break
+		case "naming":
+			if ParseNaming(k) != NamingDefault {
+				file.WriteString("{naming: ")
+				file.WriteString(k)
+				file.WriteString("}\n")
+			}
+			break
 		default:
 			fmt.Println("Unknown book setting: ", i, " -> ", k)
 			break
@@ -286,27 +404,94 @@ func editBookPostHandler(w http.ResponseWriter, r *http.Request, p httprouter.Pa
 	}
 
 	for _, s := range songs {
-		_, err = file.WriteString(strings.TrimSpace(s))
-		if err == nil {
-			_, err = file.WriteString("\n")
-		}
-
-		if err != nil {
-			fmt.Println(err)
+		if !validSonglistSong(s) {
+			http.Error(w, fmt.Sprintf("invalid song %q", s), http.StatusBadRequest)
 			return
 		}
+
+		file.WriteString(strings.TrimSpace(s))
+		file.WriteString("\n")
+	}
+
+	name := strings.TrimSpace(settings["name"])
+	if !saveEdit(w, r, bookStore, name, file.Bytes(), p.ByName("book")) {
+		return
+	}
+
+	if err := catalog.ReloadBooks(name); err != nil {
+		log.Println(err)
+	}
+}
+
+//saveEdit saves the content of a song or songbook from its edit page,
+//responding with an error if it could not be. If the page sent the Version
+//of the file <base> it was opened with, and someone else has changed that
+//file since, the response is a 409 editConflict so the user can choose
+//which version to keep. The edit pages always send the version; a request
+//without one (e.g. from a page opened before versions were checked) is
+//saved without the check.
+func saveEdit(w http.ResponseWriter, r *http.Request, s fileStore, name string, content []byte, base string) bool {
+	change := requestChange(r, r.PostFormValue("message"), "Edited")
+
+	var err error
+	if version, ok := r.PostForm["version"]; ok {
+		err = s.SaveIfUnchanged(name, content, change, base, version[0])
+	} else {
+		err = s.Save(name, content, change)
+	}
+
+	if errors.Is(err, ErrChanged) {
+		writeEditConflict(w, s, base, content, err)
+		return false
+	}
+
+	if err != nil {
+		storeHTTPError(w, err)
+		return false
+	}
+
+	return true
+}
+
+//writeEditConflict responds to a save of <content> that failed with
+//ErrChanged, with a 409 editConflict giving the current content and Version
+//of the file <base>.
+func writeEditConflict(w http.ResponseWriter, s fileStore, base string, content []byte, err error) {
+	current, readErr := s.Read(base)
+	if readErr != nil && storeStatus(readErr) != http.StatusNotFound {
+		storeHTTPError(w, readErr)
+		return
+	}
+
+	//a file that has been deleted has no version
+	version := ""
+	if readErr == nil {
+		version = contentVersion(current)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	conflict := editConflict{err.Error(), string(current), version, diffLines(string(current), string(content))}
+	if err := json.NewEncoder(w).Encode(conflict); err != nil {
+		log.Println(err)
 	}
 }
 
 func editBookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	temp, err := template.ParseFiles(
-		"templates/index.tmpl",
-		"templates/book_edit.tmpl")
+	version, err := bookStore.Version(p.ByName("book"))
+	if err != nil {
+		storeHTTPError(w, err)
+		return
+	}
+
+	temp, err := template.ParseFiles(templateFiles(
+		"index.tmpl",
+		"book_edit.tmpl")...)
 	if err != nil {
 		panic(err)
 	}
 
-	sbook, err := ParseSongbookFile(books_root+"/"+p.ByName("book")+".songlist", songs_root)
+	sbook, err := catalog.Book(p.ByName("book"))
 	var pBook Songbook
 	if err == nil {
 		pBook = *sbook
@@ -314,7 +499,8 @@ func editBookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params
 
 	book_data := &BookPage{
 		Songbook:  pBook,
-		IndexPage: getBasicIndexData(),
+		Version:   version,
+		IndexPage: getBasicIndexData(r),
 	}
 
 	if err := temp.ExecuteTemplate(w, "book_edit.tmpl", book_data); err != nil {
@@ -323,23 +509,25 @@ func editBookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params
 }
 
 func bookIndexHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	sbook, err := ParseSongbookFile(books_root+"/"+p.ByName("book")+".songlist", songs_root)
+	sbook, err := catalog.Book(p.ByName("book"))
 
 	if err != nil {
 		fmt.Println(err)
+		http.NotFound(w, r)
+		return
 	}
 
-	index := getBasicIndexData()
+	index := getBasicIndexData(r)
 	index.SelectedBook = sbook.Title
 
 	book_data := &BookPage{
 		Songbook:  *sbook,
 		IndexPage: index}
 
-	temp, err := template.ParseFiles(
-		"templates/index.tmpl",
-		"templates/_book_navigation.tmpl",
-		"templates/book_index.tmpl")
+	temp, err := template.ParseFiles(templateFiles(
+		"index.tmpl",
+		"_book_navigation.tmpl",
+		"book_index.tmpl")...)
 	if err != nil {
 		panic(err)
 	}
@@ -353,17 +541,12 @@ func bookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	num := p.ByName("number")
 	n, _ := strconv.Atoi(num)
 
-	var transpose = r.FormValue("transpose")
-	if len(transpose) == 0 {
-		transpose = "0"
-	}
-	t, err := strconv.Atoi(transpose)
-
-	sbook, err := ParseSongbookFile(books_root+"/"+p.ByName("book")+".songlist", songs_root)
+	sbook, err := catalog.Book(p.ByName("book"))
 
 	if err != nil {
 		fmt.Println(err)
 		http.NotFound(w, r)
+		return
 	}
 
 	keys := GetSongOrder(sbook)
@@ -394,17 +577,17 @@ func bookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	//Number does not exist in songbook
 	if !ok {
 		index := httprouter.CleanPath(r.URL.String() + "/../../index")
-		last_err = "Song '" + strconv.Itoa(n) + "' not found in songbook."
+		catalog.SetError("Song '" + strconv.Itoa(n) + "' not found in songbook.")
 		http.Redirect(w, r, index, 302)
 		return
 	}
 
-	index := getBasicIndexData()
+	index := getBasicIndexData(r)
 	index.SelectedSong = song.Title
 	index.SelectedBook = sbook.Title
-	song.Transpose(t)
+	applyRequestOptions(&song, r)
 
-	updateRecent(song.Link(), song.Title)
+	catalog.AddRecent(song.Link(), song.Title)
 
 	page_data := &SongPage{
 		Song:      song,
@@ -414,13 +597,13 @@ func bookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		Selected:  song.SongNumber,
 		IndexPage: index}
 
-	temp, err := template.ParseFiles(
-		"templates/index.tmpl",
-		"templates/_song_select.tmpl",
-		"templates/_book_select.tmpl",
-		"templates/_display_song.tmpl",
-		"templates/_book_navigation.tmpl",
-		"templates/book_song.tmpl")
+	temp, err := template.ParseFiles(templateFiles(
+		"index.tmpl",
+		"_song_select.tmpl",
+		"_book_select.tmpl",
+		"_display_song.tmpl",
+		"_book_navigation.tmpl",
+		"book_song.tmpl")...)
 	if err != nil {
 		panic(err)
 	}
@@ -430,55 +613,227 @@ func bookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	}
 }
 
-func updateRecent(link string, title string) {
-	//if the song is already in the list, move it to the top
-	for i, dl := range recent {
-		if dl.Title == title {
-			//remove element
-			recent = append(recent[:i], recent[i+1:]...)
-			break
-		}
+//getTranspose returns the number of half-notes in the given "transpose"
+//option, wrapped into the range 0-11.
+//Missing or invalid values are treated as no transposition.
+func getTranspose(value string) int {
+	t, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0
+	}
+
+	return ((t % 12) + 12) % 12
+}
+
+//applyRequestOptions applies the display options of the request to the
+//given Song, see applySongOptions.
+func applyRequestOptions(song *Song, r *http.Request) {
+	applySongOptions(song, r.FormValue)
+}
+
+//applySongOptions applies the given display options to the Song,
+//where <option> returns the value of the named option ("" if not given):
+//"key" (or "transpose" if no key is given) to transpose the Chords,
+//"capo" to show the shapes to play with a capo on, and
+//"concert" to show the sounding chords alongside the capo shapes, and
+//"notation" to show chords as Nashville numbers or Roman numerals, and
+//"naming" to show chords with another naming system (e.g. German), and
+//"diagrams" to show chord diagrams for an instrument (e.g. guitar).
+//Options that are not given are left unchanged, so songs in a
+//songbook keep the key chosen in the songlist and the song's own capo.
+func applySongOptions(song *Song, option func(name string) string) {
+	if key, ok := ParseKey(option("key")); ok {
+		song.TransposeToKey(key)
+	} else if len(option("transpose")) > 0 {
+		song.Transpose(getTranspose(option("transpose")))
 	}
 
-	recent = append([]DisplayList{DisplayList{Link: link, Title: title}}, recent...)
-	if len(recent) > 5 {
-		recent = recent[0:5]
+	capo := song.GetCapo()
+	if c, err := strconv.Atoi(option("capo")); err == nil && c >= 0 {
+		capo = c
 	}
+	song.SetCapo(capo, len(option("concert")) > 0)
+
+	applyBookOptions(song, option)
+}
+
+//applyBookOptions applies the display options that are used for whole
+//songbooks ("notation", "naming" and "diagrams") to the given Song.
+func applyBookOptions(song *Song, option func(name string) string) {
+	if len(option("notation")) > 0 {
+		song.SetNotation(ParseNotation(option("notation")))
+	}
+
+	if len(option("naming")) > 0 {
+		song.SetNaming(ParseNaming(option("naming")))
+	}
+
+	song.SetInstrument(ParseInstrument(option("diagrams")))
 }
 
 func editSongPostHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	raw_content := r.PostFormValue("content")
 	var content string
+	if err := json.Unmarshal([]byte(raw_content), &content); err != nil {
+		http.Error(w, "invalid song content", http.StatusBadRequest)
+		return
+	}
+
+	if !saveEdit(w, r, songStore, p.ByName("song"), []byte(content), p.ByName("song")) {
+		return
+	}
+
+	if err := catalog.ReloadSongs(p.ByName("song")); err != nil {
+		log.Println(err)
+	}
+}
+
+//formatSongHandler formats the posted song content (see FormatSong),
+//responding with the formatted content, or the unchanged content and the
+//problems found if it could not be formatted.
+func formatSongHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	raw_content := r.PostFormValue("content")
+	var content string
+
+	if err := json.Unmarshal([]byte(raw_content), &content); err != nil {
+		http.Error(w, "invalid song content", http.StatusBadRequest)
+		return
+	}
+
+	result := struct {
+		Content     string   `json:"content"`
+		Diagnostics []string `json:"diagnostics"`
+	}{content, make([]string, 0)}
+
+	formatted, diags, err := FormatSong([]byte(content), p.ByName("song")+".song")
+	if err == nil {
+		result.Content = string(formatted)
+	}
+	for _, d := range diags {
+		result.Diagnostics = append(result.Diagnostics, d.String())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Println(err)
+	}
+}
 
-	_ = json.Unmarshal([]byte(raw_content), &content)
+//lintSongHandler checks the posted song content with every LintRule,
+//responding with the problems found.
+func lintSongHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	raw_content := r.PostFormValue("content")
+	var content string
 
-	filename := getSongFilename(p.ByName("song"))
+	if err := json.Unmarshal([]byte(raw_content), &content); err != nil {
+		http.Error(w, "invalid song content", http.StatusBadRequest)
+		return
+	}
 
-	err := ioutil.WriteFile(filename, []byte(content), 0644)
+	filename, err := songStore.Path(p.ByName("song"))
 	if err != nil {
+		storeHTTPError(w, err)
+		return
+	}
+
+	file := NewLintFile(filename, []byte(content))
+	ctx := catalog.LintContext()
+
+	result := make([]string, 0)
+	for _, d := range Lint([]LintFile{file}, ctx) {
+		d.File = p.ByName("song") + ".song"
+		result = append(result, d.String())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Println(err)
+	}
+}
+
+//previewSongHandler renders the posted song content as the song page shows
+//it, without saving it, responding with the HTML and the problems found in
+//the song, so the song edit page can show the song as it is typed.
+//With "format=pdf" the response is the song as a PDF instead.
+func previewSongHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	raw_content := r.PostFormValue("content")
+	var content string
+
+	if err := json.Unmarshal([]byte(raw_content), &content); err != nil {
+		http.Error(w, "invalid song content", http.StatusBadRequest)
+		return
+	}
+
+	//only the song itself is checked, the Check button also compares it with the other songs
+	file := NewLintFile(p.ByName("song")+".song", []byte(content))
+
+	if r.FormValue("format") == "pdf" {
+		if file.Song == nil {
+			http.Error(w, "the song could not be read", http.StatusBadRequest)
+			return
+		}
+
+		pdf, err := WriteSongPDF(file.Song)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "the song could not be rendered", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		if _, err := pdf.WriteTo(w); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	result := struct {
+		HTML        string   `json:"html"`
+		Diagnostics []string `json:"diagnostics"`
+	}{"", make([]string, 0)}
+
+	for _, d := range Lint([]LintFile{file}, LintContext{}) {
+		result.Diagnostics = append(result.Diagnostics, d.String())
+	}
+
+	if file.Song != nil {
+		temp, err := template.ParseFiles(templateFiles("_display_song.tmpl")...)
+		if err != nil {
+			panic(err)
+		}
+
+		var html bytes.Buffer
+		if err := temp.ExecuteTemplate(&html, "_song_body", file.Song); err != nil {
+			log.Println(err)
+		}
+		result.HTML = html.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
 		log.Println(err)
 	}
 }
 
 func editSongHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	temp, err := template.ParseFiles(
-		"templates/index.tmpl",
-		"templates/song_edit.tmpl")
+	b, err := songStore.Read(p.ByName("song"))
 	if err != nil {
-		panic(err)
+		storeHTTPError(w, err)
+		return
 	}
 
-	filename := getSongFilename(p.ByName("song"))
-	b, err := ioutil.ReadFile(filename)
+	temp, err := template.ParseFiles(templateFiles(
+		"index.tmpl",
+		"song_edit.tmpl")...)
 	if err != nil {
-		log.Println(err)
-		return
+		panic(err)
 	}
 
 	page_data := &EditSongPage{
 		Title:     p.ByName("song"),
 		Content:   string(b),
-		IndexPage: getBasicIndexData(),
+		Version:   contentVersion(b),
+		IndexPage: getBasicIndexData(r),
 	}
 
 	if err := temp.ExecuteTemplate(w, "song_edit.tmpl", page_data); err != nil {
@@ -486,37 +841,239 @@ func editSongHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params
 	}
 }
 
-func songHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	var transpose = r.FormValue("transpose")
-	if len(transpose) == 0 {
-		transpose = "0"
+// newSongHandler is an HTTP handler that serves the page for creating a song.
+func newSongHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	data := &NewSongPage{IndexPage: getBasicIndexData(r)}
+	data.Title = "New Song"
+
+	executeNewSongTemplate(w, data)
+}
+
+func executeNewSongTemplate(w http.ResponseWriter, data *NewSongPage) {
+	temp, err := template.ParseFiles(templateFiles(
+		"index.tmpl",
+		"song_new.tmpl")...)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := temp.ExecuteTemplate(w, "song_new.tmpl", data); err != nil {
+		log.Println(err)
+	}
+}
+
+// newSongPostHandler creates a song with the posted file name and title,
+// then goes on to its edit page.
+func newSongPostHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	title := strings.TrimSpace(r.PostFormValue("title"))
+	if len(title) == 0 {
+		title = name
+	}
+
+	var err error
+	if strings.ContainsAny(title, "\r\n{}") {
+		err = fmt.Errorf("invalid song title %q", title)
+	} else {
+		content := []byte("{title: " + title + "}\n\n")
+		err = songStore.SaveNew(name, content, requestChange(r, r.PostFormValue("message"), "Created"))
+	}
+
+	if err != nil {
+		data := &NewSongPage{Name: name, SongTitle: title, IndexPage: getBasicIndexData(r)}
+		data.Title = "New Song"
+		data.Error = err.Error()
+
+		status := http.StatusBadRequest
+		if _, ok := err.(*StoreError); ok {
+			status = storeStatus(err)
+		}
+		w.WriteHeader(status)
+		executeNewSongTemplate(w, data)
+		return
+	}
+
+	if err := catalog.ReloadSongs(name); err != nil {
+		log.Println(err)
+	}
+
+	http.Redirect(w, r, "/song/"+url.PathEscape(name)+"/edit", http.StatusSeeOther)
+}
+
+// renameSongHandler renames a song to the posted name, changing the
+// songlists with it in them to the new name, and responds with the
+// songbooks that were changed.
+func renameSongHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	from := p.ByName("song")
+	to := strings.TrimSpace(r.PostFormValue("name"))
+
+	change := requestChange(r, r.PostFormValue("message"), fmt.Sprintf("Renamed %s to %s", from, to))
+	books, err := renameSong(from, to, change)
+	if err != nil {
+		storeHTTPError(w, err)
+		return
+	}
+
+	if err := catalog.ReloadSongs(from, to); err != nil {
+		log.Println(err)
+	}
+
+	result := struct {
+		Link  string   `json:"link"`
+		Books []string `json:"books"`
+	}{to, books}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Println(err)
+	}
+}
+
+// editSongDeleteHandler deletes a song, unless a songbook has it in it.
+func editSongDeleteHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	name := p.ByName("song")
+
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	books, err := songlistsWith(name)
+	if err != nil {
+		storeHTTPError(w, err)
+		return
+	}
+	if len(books) > 0 {
+		http.Error(w, fmt.Sprintf("song %q is in the songbooks %s, take it out of them first", name, strings.Join(books, ", ")), http.StatusConflict)
+		return
+	}
+
+	if err := songStore.Delete(name, requestChange(r, r.FormValue("message"), "Deleted")); err != nil {
+		storeHTTPError(w, err)
+		return
 	}
-	t, err := strconv.Atoi(transpose)
 
-	data, err := loadSongFile(p.ByName("song"), t)
+	if err := catalog.ReloadSongs(name); err != nil {
+		log.Println(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//renameSong renames the song <from> to <to>, which must not already exist,
+//changing every songlist with the song in it to the new name.
+//It returns the songbooks that were changed. If the rename fails part way,
+//the songlists and songs are put back as they were.
+func renameSong(from string, to string, change Change) ([]string, error) {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	content, err := songStore.Read(from)
+	if err != nil {
+		return nil, err
+	}
+
+	books, err := songlistsWith(from)
+	if err != nil {
+		return nil, err
+	}
+
+	//every songlist is read and changed before anything is written
+	originals := make([][]byte, len(books))
+	renamed := make([][]byte, len(books))
+	for i, book := range books {
+		if originals[i], err = bookStore.Read(book); err != nil {
+			return nil, err
+		}
+		renamed[i], _ = renameInSonglist(originals[i], from, to)
+	}
+
+	if err := songStore.SaveNew(to, content, change); err != nil {
+		return nil, err
+	}
+
+	//the song is in both places until every songlist has been changed,
+	//if anything fails the changes made so far are undone
+	saved := 0
+	undo := func(err error) ([]string, error) {
+		undone := Change{change.Author, fmt.Sprintf("Undid renaming %s to %s, which failed", from, to)}
+		for i := saved - 1; i >= 0; i-- {
+			if err := bookStore.Save(books[i], originals[i], undone); err != nil {
+				log.Println(errors.Unwrap(err))
+			}
+		}
+		if err := songStore.Delete(to, undone); err != nil {
+			log.Println(errors.Unwrap(err))
+		}
+
+		return nil, err
+	}
+
+	for i, book := range books {
+		if err := bookStore.Save(book, renamed[i], change); err != nil {
+			return undo(err)
+		}
+		saved++
+	}
+
+	if err := songStore.Delete(from, change); err != nil {
+		return undo(err)
+	}
+
+	return books, nil
+}
+
+//songlistsWith returns the songbooks whose songlists have the song in them.
+func songlistsWith(song string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(books_root, "*.songlist"))
+	if err != nil {
+		return nil, &StoreError{"list", "songbook", books_root, err}
+	}
+
+	books := make([]string, 0)
+	for _, f := range files {
+		book := strings.TrimSuffix(filepath.Base(f), ".songlist")
+		if !validName(book) {
+			continue
+		}
+
+		b, err := bookStore.Read(book)
+		if err != nil {
+			return nil, err
+		}
+
+		if songlistHasSong(b, song) {
+			books = append(books, book)
+		}
+	}
+
+	return books, nil
+}
+
+func songHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	data, err := catalog.Song(p.ByName("song"))
 	if err != nil {
 		log.Println(err)
 
 		home := httprouter.CleanPath(r.URL.String() + "/../..")
-		last_err = "Song '" + p.ByName("song") + "' not found."
+		catalog.SetError("Song '" + p.ByName("song") + "' not found.")
 		http.Redirect(w, r, home, 302)
 		return
 	}
 
-	updateRecent(p.ByName("song"), data.Title)
+	applyRequestOptions(data, r)
+	catalog.AddRecent(p.ByName("song"), data.Title)
 
-	index := getBasicIndexData()
+	index := getBasicIndexData(r)
 	index.SelectedSong = data.Title
 
 	page_data := &SongPage{
 		Song:      *data,
 		IndexPage: index}
 
-	temp, err := template.ParseFiles(
-		"templates/index.tmpl",
-		"templates/_song_select.tmpl",
-		"templates/_display_song.tmpl",
-		"templates/song.tmpl")
+	temp, err := template.ParseFiles(templateFiles(
+		"index.tmpl",
+		"_song_select.tmpl",
+		"_display_song.tmpl",
+		"song.tmpl")...)
 	if err != nil {
 		panic(err)
 	}
@@ -527,19 +1084,15 @@ func songHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 }
 
 func songPdfHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	var transpose = r.FormValue("transpose")
-	if len(transpose) == 0 {
-		transpose = "0"
-	}
-	t, err := strconv.Atoi(transpose)
-
-	song, err := loadSongFile(p.ByName("song"), t)
+	song, err := catalog.Song(p.ByName("song"))
 	if err != nil {
 		log.Println(err)
 		http.NotFound(w, r)
 		return
 	}
 
+	applyRequestOptions(song, r)
+
 	pdf, err := WriteSongPDF(song)
 
 	if err != nil {
@@ -558,7 +1111,7 @@ func songPdfHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params)
 }
 
 func bookPdfHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	sbook, err := ParseSongbookFile(books_root+"/"+p.ByName("book")+".songlist", songs_root)
+	sbook, err := catalog.Book(p.ByName("book"))
 
 	if err != nil {
 		log.Println(err)
@@ -566,6 +1119,12 @@ func bookPdfHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params)
 		return
 	}
 
+	//Apply the requested notation, naming and diagrams to every song
+	for i, song := range sbook.Songs {
+		applyBookOptions(&song, r.FormValue)
+		sbook.Songs[i] = song
+	}
+
 	var pdf *bytes.Buffer
 
 	ver := p.ByName("version")
@@ -594,51 +1153,6 @@ func bookPdfHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params)
 	w.Write([]byte("PDF Generated"))
 }
 
-func loadSongs(path string) error {
-	song_files, err := ioutil.ReadDir(songs_root)
-	if err != nil {
-		return err
-	}
-
-	for _, f := range song_files {
-		if !f.IsDir() && strings.HasSuffix(strings.ToLower(f.Name()), ".song") {
-			song, err := ParseSongFile(songs_root+"/"+f.Name(), 0)
-			if err != nil {
-				return err
-			}
-
-			link := song.Filename[0 : len(song.Filename)-len(".song")]
-			loadedSongs = append(loadedSongs, DisplayList{Link: link, Title: song.Title})
-		}
-	}
-
-	return err
-}
-
-func loadBooks(path string) error {
-	book_files, err := ioutil.ReadDir(books_root)
-	if err != nil {
-		return err
-	}
-
-	loadedBooks = make([]DisplayList, 0)
-
-	for _, f := range book_files {
-		if !f.IsDir() && strings.HasSuffix(strings.ToLower(f.Name()), ".songlist") {
-			book, err := ParseSongbookFile(books_root+"/"+f.Name(), songs_root)
-			if err != nil {
-				return err
-			}
-			link := book.Filename[0:len(book.Filename)-len(".songlist")] + "/index"
-			loadedBooks = append(loadedBooks, DisplayList{Link: link, Title: book.Title})
-		}
-	}
-
-	fmt.Printf("%d Books loaded.\n", len(loadedBooks))
-
-	return err
-}
-
 // readLines reads a whole file into memory
 // and returns a slice of its lines.
 func readLines(path string) ([]string, error) {
@@ -0,0 +1,210 @@
+package main
+
+import "strings"
+
+//NamingSystem is a way of naming notes, e.g. English (A B C),
+//German (where B natural is H and Bb is B), or fixed-do solfège (Do Re Mi).
+type NamingSystem int
+
+const (
+	//NamingDefault keeps chords in the naming system they are written in,
+	//and detects the naming system when parsing.
+	NamingDefault NamingSystem = 0
+	NamingEnglish NamingSystem = 1
+	NamingGerman  NamingSystem = 2
+	NamingSolfege NamingSystem = 3
+)
+
+//Namings lists all available NamingSystem settings, in display order.
+var Namings = []NamingSystem{NamingDefault, NamingEnglish, NamingGerman, NamingSolfege}
+
+//detectNamings is the order naming systems are tried in when the naming
+//system of a chord is not known.
+//Solfège is tried first as its names are unambiguous ("Do", "Sol"),
+//German last as "B" means Bb in German but B in English, so German songs
+//should set the naming system explicitly.
+var detectNamings = []NamingSystem{NamingSolfege, NamingEnglish, NamingGerman}
+
+//ParseNaming returns the NamingSystem with the given name, i.e. "english",
+//"german", "solfege" (or "latin"). Unknown names return NamingDefault.
+func ParseNaming(name string) NamingSystem {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "latin", "solfège":
+		return NamingSolfege
+	}
+
+	for _, n := range Namings {
+		if name == n.String() {
+			return n
+		}
+	}
+
+	return NamingDefault
+}
+
+//String returns the name of this NamingSystem, as used in tags and URLs.
+func (naming NamingSystem) String() string {
+	switch naming {
+	case NamingEnglish:
+		return "english"
+	case NamingGerman:
+		return "german"
+	case NamingSolfege:
+		return "solfege"
+	}
+
+	return ""
+}
+
+//Title returns the name of this NamingSystem for display.
+func (naming NamingSystem) Title() string {
+	switch naming {
+	case NamingEnglish:
+		return "English (A B C)"
+	case NamingGerman:
+		return "German (A H C)"
+	case NamingSolfege:
+		return "Solfège (La Si Do)"
+	}
+
+	return "As written"
+}
+
+var solfegeNames = []struct {
+	name   string
+	letter byte
+}{
+	{"Do", 'C'},
+	{"Re", 'D'},
+	{"Mi", 'E'},
+	{"Fa", 'F'},
+	{"Sol", 'G'},
+	{"La", 'A'},
+	{"Si", 'B'},
+	{"Ti", 'B'},
+}
+
+//Name returns the name of this Note in the given NamingSystem.
+func (note Note) Name(naming NamingSystem) string {
+	if !note.IsValid() {
+		return ""
+	}
+
+	switch naming {
+	case NamingGerman:
+		if note.Letter == 'B' {
+			if note.Accidental == -1 {
+				return "B"
+			}
+
+			return "H" + accidentalSuffix(note.Accidental)
+		}
+	case NamingSolfege:
+		for _, s := range solfegeNames {
+			if s.letter == note.Letter {
+				return s.name + accidentalSuffix(note.Accidental)
+			}
+		}
+	}
+
+	return note.String()
+}
+
+//Name returns the name of this Key in the given NamingSystem.
+func (key Key) Name(naming NamingSystem) string {
+	if !key.IsValid() {
+		return ""
+	}
+
+	if key.Minor {
+		return key.Tonic.Name(naming) + "m"
+	}
+
+	return key.Tonic.Name(naming)
+}
+
+//parseNoteName parses the note name at the start of <text>, written in the
+//given NamingSystem. Returns the Note and the rest of the text,
+//ok is false if the text does not start with a note name.
+func parseNoteName(text string, naming NamingSystem) (note Note, rest string, ok bool) {
+	switch naming {
+	case NamingEnglish:
+		if len(text) == 0 || text[0] < 'A' || text[0] > 'G' {
+			return Note{}, text, false
+		}
+
+		note.Letter = text[0]
+		rest = text[1:]
+	case NamingGerman:
+		if len(text) == 0 || text[0] < 'A' || text[0] > 'H' {
+			return Note{}, text, false
+		}
+
+		note.Letter = text[0]
+		rest = text[1:]
+
+		switch note.Letter {
+		case 'H':
+			note.Letter = 'B'
+		case 'B':
+			note.Accidental = -1
+		}
+
+		//Fis, Cis, ... and Ges, Des, ... plus As and Es (but not Asus)
+		if strings.HasPrefix(rest, "is") {
+			return note.sharpen(1), rest[len("is"):], true
+		} else if strings.HasPrefix(rest, "es") {
+			return note.sharpen(-1), rest[len("es"):], true
+		} else if (note.Letter == 'A' || note.Letter == 'E') &&
+			strings.HasPrefix(rest, "s") && !strings.HasPrefix(rest, "sus") {
+			return note.sharpen(-1), rest[len("s"):], true
+		}
+	case NamingSolfege:
+		for _, s := range solfegeNames {
+			if !strings.HasPrefix(text, s.name) {
+				continue
+			}
+
+			note.Letter = s.letter
+			rest = text[len(s.name):]
+
+			//"Fadd9" and "Faug" are English chords
+			if s.name == "Fa" && (strings.HasPrefix(rest, "dd") || strings.HasPrefix(rest, "ug")) {
+				return Note{}, text, false
+			}
+			break
+		}
+
+		if !note.IsValid() {
+			return Note{}, text, false
+		}
+	default:
+		return Note{}, text, false
+	}
+
+	for _, acc := range []string{"#", "♯", "b", "♭"} {
+		if strings.HasPrefix(rest, acc) {
+			return note.sharpen(parseAccidental(acc)), rest[len(acc):], true
+		}
+	}
+
+	return note, rest, true
+}
+
+//sharpen returns this Note with <change> added to its accidental.
+func (note Note) sharpen(change int) Note {
+	note.Accidental += change
+	return note
+}
+
+func parseAccidental(acc string) int {
+	switch acc {
+	case "#", "♯":
+		return 1
+	case "b", "♭":
+		return -1
+	}
+
+	return 0
+}
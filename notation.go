@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+//Notation is the way Chords are written when displayed.
+type Notation int
+
+const (
+	NotationLetters   Notation = 0
+	NotationNashville Notation = 1
+	NotationRoman     Notation = 2
+)
+
+//Notations lists all available Notation settings, in display order.
+var Notations = []Notation{NotationLetters, NotationNashville, NotationRoman}
+
+//ParseNotation returns the Notation with the given name, i.e. "letters",
+//"nashville" or "roman". Unknown names return NotationLetters.
+func ParseNotation(name string) Notation {
+	for _, n := range Notations {
+		if strings.EqualFold(name, n.String()) {
+			return n
+		}
+	}
+
+	return NotationLetters
+}
+
+//String returns the name of this Notation, as used in URLs.
+func (notation Notation) String() string {
+	switch notation {
+	case NotationNashville:
+		return "nashville"
+	case NotationRoman:
+		return "roman"
+	}
+
+	return "letters"
+}
+
+//Title returns the name of this Notation for display.
+func (notation Notation) Title() string {
+	switch notation {
+	case NotationNashville:
+		return "Nashville numbers"
+	case NotationRoman:
+		return "Roman numerals"
+	}
+
+	return "Chord names"
+}
+
+//pitchDegrees gives the degree and accidental for each pitch above the tonic,
+//used when a note is spelled too unusually to use its letter.
+var pitchDegrees = [][2]int{
+	{1, 0},
+	{2, -1},
+	{2, 0},
+	{3, -1},
+	{3, 0},
+	{4, 0},
+	{4, 1},
+	{5, 0},
+	{6, -1},
+	{6, 0},
+	{7, -1},
+	{7, 0},
+}
+
+var romanNumerals = []string{"I", "II", "III", "IV", "V", "VI", "VII"}
+
+//Degree returns the scale degree (1-7) of the given Note in this Key,
+//along with its accidental relative to the major scale of the tonic.
+//Degrees are always relative to the major scale, so in a minor key the
+//relative major chord is a flat 3 (e.g. in Am, C is b3).
+func (key Key) Degree(note Note) (degree int, accidental int) {
+	letters := "CDEFGAB"
+	degree = (strings.IndexByte(letters, note.Letter) - strings.IndexByte(letters, key.Tonic.Letter) + 7) % 7
+
+	scale := Key{Tonic: key.Tonic}.Scale()
+	accidental = mod12(note.Pitch()-scale[degree].Pitch()+6) - 6
+
+	if accidental < -1 || accidental > 1 {
+		d := pitchDegrees[mod12(note.Pitch()-key.Tonic.Pitch())]
+		return d[0], d[1]
+	}
+
+	return degree + 1, accidental
+}
+
+//degreeName returns the Nashville number of the given Note in <key>,
+//e.g. "4", "b7" or "#4".
+func degreeName(note Note, key Key) string {
+	degree, acc := key.Degree(note)
+
+	return accidentalPrefix(acc) + strconv.Itoa(degree)
+}
+
+func accidentalPrefix(acc int) string {
+	if acc > 0 {
+		return strings.Repeat("#", acc)
+	}
+
+	return strings.Repeat("b", -acc)
+}
+
+//renderChordNumber returns the chord for the given parts written in the
+//given Notation relative to <key>, after transposing by <change> half-notes.
+//Nashville numbers keep the chord quality, e.g. "6m" or "5/7".
+//Roman numerals are lower case for minor chords, e.g. "vi" or "IV".
+func renderChordNumber(root Note, quality string, bass Note, change int, key Key, notation Notation) string {
+	root = root.transpose(change, key)
+	text := ""
+
+	if notation == NotationRoman {
+		degree, acc := key.Degree(root)
+		numeral := romanNumerals[degree-1]
+
+		if strings.HasPrefix(quality, "min") {
+			numeral = strings.ToLower(numeral)
+			quality = quality[len("min"):]
+		} else if strings.HasPrefix(quality, "m") && !strings.HasPrefix(quality, "maj") {
+			numeral = strings.ToLower(numeral)
+			quality = quality[len("m"):]
+		} else if strings.HasPrefix(quality, "dim") {
+			numeral = strings.ToLower(numeral)
+			quality = "°" + quality[len("dim"):]
+		}
+
+		text = accidentalPrefix(acc) + numeral + quality
+	} else {
+		text = degreeName(root, key) + quality
+	}
+
+	if bass.IsValid() {
+		text += "/" + degreeName(bass.transpose(change, key), key)
+	}
+
+	return text
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//SearchIndex is an inverted index of the words in songs: their titles,
+//lyrics, comments, sections and other details. Words are matched ignoring
+//case and accents, so "a" finds "ā". It is safe for concurrent use.
+type SearchIndex struct {
+	mu    sync.RWMutex
+	docs  map[string]*searchDoc
+	terms map[string]map[string]int
+}
+
+//searchDoc is what is indexed for a single song.
+type searchDoc struct {
+	title     string
+	firstLine string
+	lines     []searchLine
+}
+
+//searchLine is a line of text in a song, and the weight of a match in it.
+//Words is the folded words of the text, separated (and surrounded) by spaces.
+type searchLine struct {
+	text   string
+	words  string
+	weight int
+}
+
+//Weights of a match in each part of a song.
+const (
+	searchWeightTitle     = 10
+	searchWeightFirstLine = 6
+	searchWeightChorus    = 3
+	searchWeightDetails   = 2
+	searchWeightLyrics    = 1
+)
+
+//SearchResult is a song found by a search. Match is the line of the song
+//that best matches the search.
+type SearchResult struct {
+	Link      string `json:"link"`
+	Title     string `json:"title"`
+	FirstLine string `json:"first_line"`
+	Match     string `json:"match"`
+	Score     int    `json:"score"`
+}
+
+//NewSearchIndex returns an empty SearchIndex.
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		docs:  make(map[string]*searchDoc),
+		terms: make(map[string]map[string]int),
+	}
+}
+
+//Add indexes the Song with the given link, replacing any Song already
+//indexed with that link.
+func (index *SearchIndex) Add(link string, song *Song) {
+	doc := newSearchDoc(song)
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.remove(link)
+	index.docs[link] = doc
+
+	for _, line := range doc.lines {
+		for _, term := range strings.Fields(line.words) {
+			if index.terms[term] == nil {
+				index.terms[term] = make(map[string]int)
+			}
+			index.terms[term][link] += line.weight
+		}
+	}
+}
+
+//Remove removes the Song with the given link from the index.
+func (index *SearchIndex) Remove(link string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.remove(link)
+}
+
+func (index *SearchIndex) remove(link string) {
+	doc, ok := index.docs[link]
+	if !ok {
+		return
+	}
+
+	for _, line := range doc.lines {
+		for _, term := range strings.Fields(line.words) {
+			delete(index.terms[term], link)
+			if len(index.terms[term]) == 0 {
+				delete(index.terms, term)
+			}
+		}
+	}
+
+	delete(index.docs, link)
+}
+
+func newSearchDoc(song *Song) *searchDoc {
+	doc := &searchDoc{title: song.Title}
+
+	add := func(text string, weight int) {
+		if words := searchWords(text); len(words) > 0 {
+			doc.lines = append(doc.lines, searchLine{text, " " + strings.Join(words, " ") + " ", weight})
+		}
+	}
+
+	add(song.Title, searchWeightTitle)
+
+	for _, stanza := range song.Stanzas {
+		if stanza.IsVerbatim() {
+			continue
+		}
+
+		for _, line := range stanza.Lines {
+			switch {
+			case len(doc.firstLine) == 0 && len(strings.TrimSpace(line.Text)) > 0:
+				doc.firstLine = line.Text
+				add(line.Text, searchWeightFirstLine)
+			case stanza.IsChorus:
+				add(line.Text, searchWeightChorus)
+			default:
+				add(line.Text, searchWeightLyrics)
+			}
+		}
+
+		for _, c := range append(append([]Comment{}, stanza.BeforeComments...), stanza.AfterComments...) {
+			add(c.Text, searchWeightDetails)
+		}
+		add(stanza.Label, searchWeightDetails)
+	}
+
+	details := []string{song.Section, song.Copyright, song.Album}
+	details = append(details, song.Subtitles...)
+	details = append(details, song.Artists...)
+	details = append(details, song.Composers...)
+	details = append(details, song.Lyricists...)
+	for _, values := range song.Meta {
+		details = append(details, values...)
+	}
+	for _, c := range append(append([]Comment{}, song.BeforeComments...), song.AfterComments...) {
+		details = append(details, c.Text)
+	}
+	for _, d := range details {
+		add(d, searchWeightDetails)
+	}
+
+	return doc
+}
+
+//Search returns up to <limit> songs containing every word in the query,
+//the best matches first. The last word may be the start of a word, so
+//results can be shown while typing. Words found together, in order, rank
+//higher than words found apart.
+func (index *SearchIndex) Search(query string, limit int) []SearchResult {
+	words := searchWords(query)
+	results := make([]SearchResult, 0)
+	if len(words) == 0 {
+		return results
+	}
+
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	var scores map[string]int
+	for i, word := range words {
+		found := make(map[string]int)
+
+		for link, weight := range index.terms[word] {
+			found[link] += weight
+		}
+
+		//the last word may not be finished
+		if i == len(words)-1 {
+			for term, links := range index.terms {
+				if term != word && strings.HasPrefix(term, word) {
+					for link, weight := range links {
+						found[link] += (weight + 1) / 2
+					}
+				}
+			}
+		}
+
+		//only keep songs with every word
+		if scores != nil {
+			for link := range found {
+				if _, ok := scores[link]; !ok {
+					delete(found, link)
+				} else {
+					found[link] += scores[link]
+				}
+			}
+		}
+		scores = found
+	}
+
+	phrase := " " + strings.Join(words, " ")
+	for link, score := range scores {
+		doc := index.docs[link]
+		result := SearchResult{Link: link, Title: doc.title, FirstLine: doc.firstLine, Score: score}
+
+		//the best line: the phrase in the line with the most weight,
+		//otherwise the line with the most of the words
+		best := -1
+		for _, line := range doc.lines {
+			matched := 0
+			if strings.Contains(line.words, phrase) {
+				matched = 2 * len(words) * line.weight
+			} else {
+				for _, w := range words {
+					if strings.Contains(line.words, " "+w) {
+						matched += line.weight
+					}
+				}
+			}
+
+			if matched > best {
+				best = matched
+				result.Match = line.text
+			}
+		}
+		if best >= 2*len(words) {
+			result.Score += best
+		}
+
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Title != results[j].Title {
+			return results[i].Title < results[j].Title
+		}
+		return results[i].Link < results[j].Link
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+//searchWords returns the words in the text, folded (see foldText).
+func searchWords(text string) []string {
+	return strings.FieldsFunc(foldText(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+//foldText returns the text in lower case, without accents or apostrophes,
+//e.g. "Tā Don’t" is "ta dont".
+func foldText(text string) string {
+	var b strings.Builder
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case r == '\'' || r == '’' || unicode.Is(unicode.Mn, r):
+			//apostrophes join words, combining accents are dropped
+		case foldRunes[r] != "":
+			b.WriteString(foldRunes[r])
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+//foldRunes are the letters with accents, and the letters they are folded to.
+var foldRunes = make(map[rune]string)
+
+func init() {
+	for base, accented := range map[string]string{
+		"a": "àáâãäåāăą", "c": "çćĉċč", "d": "ďđð", "e": "èéêëēĕėęě",
+		"g": "ĝğġģ", "h": "ĥħ", "i": "ìíîïĩīĭįı", "j": "ĵ", "k": "ķ",
+		"l": "ĺļľŀł", "n": "ñńņňŉ", "o": "òóôõöøōŏő", "r": "ŕŗř",
+		"s": "śŝşšș", "t": "ţťŧț", "u": "ùúûüũūŭůűų", "w": "ŵ",
+		"y": "ýÿŷ", "z": "źżž", "ss": "ß", "ae": "æ", "oe": "œ", "th": "þ",
+	} {
+		for _, r := range accented {
+			foldRunes[r] = base
+		}
+	}
+}
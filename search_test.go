@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var foldTextTests = []struct {
+	input    string
+	expected string
+}{
+	{"Amazing Grace", "amazing grace"},
+	{"Tā Don’t Can't", "ta dont cant"},
+	{"Ko Ihowā", "ko ihowa"},
+	{"Ça Ñoño Ærø Straße", "ca nono aero strasse"},
+	//a followed by a combining macron
+	{"Ihowā", "ihowa"},
+}
+
+func TestFoldText(t *testing.T) {
+	for _, ft := range foldTextTests {
+		if actual := foldText(ft.input); actual != ft.expected {
+			t.Errorf("foldText(%q): expected %q, actual %q", ft.input, ft.expected, actual)
+		}
+	}
+}
+
+var searchSongs = map[string]string{
+	"amazing.song": "{title: Amazing Grace}\n{artist: John Newton}\n[G]Amazing grace how [C]sweet the sound\nThat saved a wretch like me\n",
+	"grace.song":   "{title: Grace Alone}\nEvery promise we can make\n\n{start_of_chorus}\nSaved by grace alone\n{end_of_chorus}\n",
+	"maori.song":   "{title: Whakaaria Mai}\n{section: Māori}\nWhakaaria mai tōu rīpeka ki au\n{comment: Slowly}\n",
+	"tab.song":     "{title: Tab}\n{start_of_tab}\ngrace|---0---|\n{end_of_tab}\n",
+}
+
+var searchTests = []struct {
+	query    string
+	expected []string
+	match    string
+}{
+	{"grace", []string{"amazing", "grace"}, "Amazing Grace"},
+	{"GRACE alone", []string{"grace"}, "Grace Alone"},
+	{"saved", []string{"grace", "amazing"}, "Saved by grace alone"},
+	//the last word may be unfinished
+	{"amazing gr", []string{"amazing"}, "Amazing Grace"},
+	{"swe", []string{"amazing"}, "Amazing grace how sweet the sound"},
+	{"sweet alone", []string{}, ""},
+	//accents are ignored, in both the song and the query
+	{"tou ripeka", []string{"maori"}, "Whakaaria mai tōu rīpeka ki au"},
+	{"māori", []string{"maori"}, "Māori"},
+	{"newton", []string{"amazing"}, "John Newton"},
+	{"slowly", []string{"maori"}, "Slowly"},
+	{"", []string{}, ""},
+	{"?!", []string{}, ""},
+	{"nothing", []string{}, ""},
+}
+
+func TestSearch(t *testing.T) {
+	c, dir := newTestCatalog(t, searchSongs, nil)
+	defer os.RemoveAll(dir)
+
+	for _, st := range searchTests {
+		results := c.Search(st.query, 0)
+
+		links := make([]string, len(results))
+		for i, r := range results {
+			links[i] = r.Link
+		}
+
+		if !reflect.DeepEqual(links, st.expected) {
+			t.Errorf("search %q: expected %v, actual %v", st.query, st.expected, links)
+		} else if len(results) > 0 && results[0].Match != st.match {
+			t.Errorf("search %q: expected match %q, actual %q", st.query, st.match, results[0].Match)
+		}
+	}
+
+	if results := c.Search("grace", 1); len(results) != 1 {
+		t.Errorf("expected 1 result with limit 1, actual %d", len(results))
+	}
+}
+
+func TestSearchIndexUpdate(t *testing.T) {
+	index := NewSearchIndex()
+
+	song, _, err := ParseSong(strings.NewReader("{title: First}\nOld words\n"), ParseOptions{Filename: "a.song"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Add("a", song)
+
+	song, _, err = ParseSong(strings.NewReader("{title: Second}\nNew words\n"), ParseOptions{Filename: "a.song"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Add("a", song)
+
+	if results := index.Search("old", 0); len(results) != 0 {
+		t.Errorf("expected old words to be removed, actual %v", results)
+	}
+	if results := index.Search("new", 0); len(results) != 1 || results[0].Title != "Second" {
+		t.Errorf("expected the new song, actual %v", results)
+	}
+
+	index.Remove("a")
+	if len(index.terms) != 0 || len(index.docs) != 0 {
+		t.Errorf("expected an empty index, actual %v %v", index.terms, index.docs)
+	}
+}
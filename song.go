@@ -4,10 +4,13 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 //Song represents an invididual song read from a .song file
@@ -19,14 +22,48 @@ type Song struct {
 	SongNumber        int
 	Stanzas           []Stanza
 	ShowStanzaNumbers bool
-	BeforeComments    []string
-	AfterComments     []string
+	BeforeComments    []Comment
+	AfterComments     []Comment
 	UseLiberationFont bool
+	Key               Key
+	Capo              int
+	Naming            NamingSystem
+	Defines           map[string]ChordShape
+	Subtitles         []string
+	Artists           []string
+	Composers         []string
+	Lyricists         []string
+	Copyright         string
+	Album             string
+	Year              string
+	Time              string
+	Tempo             string
+	Duration          string
+	Meta              map[string][]string
+	titleInferred     bool
+	keyInferred       bool
 	transpose         int
+	displayKey        Key
+	capo              int
+	showConcert       bool
+	notation          Notation
+	naming            NamingSystem
+	instrument        *Instrument
+}
+
+//ParseOptions are the settings used when reading a Song.
+//Filename is the name of the file read, used for the Song's Filename and
+//in Diagnostics. Transpose is applied to all Chords, and Naming is the
+//NamingSystem the chords are written in unless the Song has a {naming} tag.
+type ParseOptions struct {
+	Filename  string
+	Transpose int
+	Naming    NamingSystem
 }
 
 //ParseSongFile attempts to read a Song from the given filename.
 //Applying any transposition to the Chords.
+//Any problems found in the file are printed.
 //Returns the newly created Song, or error on failure.
 func ParseSongFile(filename string, transpose int) (*Song, error) {
 	file, err := os.Open(filename)
@@ -35,107 +72,236 @@ func ParseSongFile(filename string, transpose int) (*Song, error) {
 	}
 	defer file.Close()
 
-	filename = filepath.Base(filename)
+	song, diags, err := ParseSong(file, ParseOptions{Filename: filepath.Base(filename), Transpose: transpose})
+	for _, d := range diags {
+		fmt.Fprintln(os.Stderr, d)
+	}
+
+	return song, err
+}
+
+//ParseSong reads a Song in .song (ChordPro) format from <r>.
+//Returns the newly created Song along with any problems found, or error if
+//<r> could not be read.
+func ParseSong(r io.Reader, opts ParseOptions) (*Song, []Diagnostic, error) {
+	var (
+		filename  = opts.Filename
+		transpose = opts.Transpose
+		err       error
+	)
 
 	var (
 		//Song variables
 		stanzas       []Stanza
 		stanzaCount   = 1
 		title         = ""
+		titleInferred = false
 		section       = ""
-		scanner       = bufio.NewScanner(file)
+		keyText       = ""
+		naming        = opts.Naming
+		capo          = 0
+		defines       = make(map[string]ChordShape)
+		meta          = make(map[string][]string)
+		scanner       = bufio.NewScanner(r)
 		songStanzaNum = true
 		//Stanza variables
 		lines         []Line
 		isChorus      = false
+		kind          = StanzaVerse
+		label         = ""
+		stanzaEnded   = false
+		newPage       = false
+		columnBreak   = false
 		stanzaShowNum = true
 		useLibFont    = false
 	)
 
-	//We need to handle /r only as Mac OS <= 9 uses this as end-of-line marker
-	//This is based on bufio/scan.go ScanLines function
-	split := func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
-		i := bytes.IndexByte(data, '\n')
+	scanner.Split(scanSongLines)
 
-		if i < 0 {
-			i = bytes.IndexByte(data, '\r')
-		}
+	stanzaBeforeComments := make([]Comment, 0)
+	stanzaAfterComments := make([]Comment, 0)
+	songBeforeComments := make([]Comment, 0)
+	songAfterComments := make([]Comment, 0)
 
-		ind := 0
-		if i > 0 && data[i-1] == '\r' {
-			ind = -1
-		}
+	chordRegex := regexp.MustCompile("\\[.*?\\]")
+	badCommandRegex := regexp.MustCompile("\\{|\\}")
+	songStarted := false
 
-		if i >= 0 {
+	diags := make([]Diagnostic, 0)
+	lineNum := 0
+	keyLine := 0
+	//report adds a Diagnostic for the current line
+	report := func(severity Severity, column int, format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{
+			File:     filename,
+			Line:     lineNum,
+			Column:   column,
+			Severity: severity,
+			Message:  fmt.Sprintf(format, args...)})
+	}
 
-			// We have a full newline-terminated line.
-			return i + 1, data[0 : i+ind], nil
-		}
-		// If we're at EOF, we have a final, non-terminated line. Return it.
-		if atEOF {
-			return len(data), data[0 : len(data)+ind], nil
+	//endStanza adds the current Stanza to the Song, if it has any lines
+	endStanza := func() {
+		if len(lines) == 0 {
+			return
 		}
-		// Request more data.
-		return 0, nil, nil
-	}
-	scanner.Split(split)
 
-	stanzaBeforeComments := make([]string, 0)
-	stanzaAfterComments := make([]string, 0)
-	songBeforeComments := make([]string, 0)
-	songAfterComments := make([]string, 0)
+		stanzas = append(stanzas, Stanza{
+			Lines:          lines,
+			Number:         stanzaCount,
+			IsChorus:       isChorus,
+			Kind:           kind,
+			Label:          label,
+			NewPage:        newPage,
+			ColumnBreak:    columnBreak,
+			ShowNumber:     stanzaShowNum,
+			BeforeComments: stanzaBeforeComments,
+			AfterComments:  stanzaAfterComments})
 
-	chordRegex := regexp.MustCompile("\\[.*?\\]")
-	badCommandRegex := regexp.MustCompile("\\{|\\}")
-	songStarted := false
+		//Only verses get stanza numbers
+		if !isChorus && kind == StanzaVerse {
+			stanzaCount++
+		}
+
+		isChorus = false
+		kind = StanzaVerse
+		label = ""
+		stanzaEnded = false
+		newPage = false
+		columnBreak = false
+		stanzaShowNum = true
+		lines = make([]Line, 0)
+		stanzaBeforeComments = make([]Comment, 0)
+		stanzaAfterComments = make([]Comment, 0)
+	}
 
 	for scanner.Scan() {
 		line := scanner.Text()
+		lineNum++
 		if strings.Contains(line, "ā") {
 			useLibFont = true
 		}
 		echo := -1
 
 		//is this a command
-		if strings.HasPrefix(line, "{") {
-			command := strings.ToLower(line)
-			if strings.HasPrefix(command, "{start_of_chorus}") {
-				isChorus = true
-				continue
-			} else if strings.HasPrefix(command, "{end_of_chorus}") {
-				continue
-			} else if strings.HasPrefix(command, "{title:") {
-				title = parseCommand(line)
-				continue
-			} else if strings.HasPrefix(command, "{section:") {
-				section = parseCommand(line)
-				continue
-			} else if strings.HasPrefix(command, "{comments:") {
+		if strings.HasPrefix(line, "{") && !strings.HasPrefix(strings.ToLower(line), "{echo") {
+			name, value := parseDirective(line)
+
+			//{meta: name value} is the same as {name: value}
+			isMeta := name == "meta"
+			if isMeta {
+				name, value = splitDirective(value)
+			}
+
+			if comment, ok := commentStyles[name]; ok {
+				c := Comment{Text: value, Style: comment}
 				if !songStarted {
-					songBeforeComments = append(songBeforeComments, parseCommand(line))
+					songBeforeComments = append(songBeforeComments, c)
 				} else {
 					if len(lines) > 0 {
-						stanzaAfterComments = append(stanzaAfterComments, parseCommand(line))
+						stanzaAfterComments = append(stanzaAfterComments, c)
 					} else {
-						stanzaBeforeComments = append(stanzaBeforeComments, parseCommand(line))
+						stanzaBeforeComments = append(stanzaBeforeComments, c)
 					}
 				}
 				continue
-			} else if strings.HasPrefix(command, "{no_number") {
+			}
+
+			if sectionKind, ok := sectionKinds[strings.TrimPrefix(name, "start_of_")]; ok && strings.HasPrefix(name, "start_of_") {
+				//a new section ends the previous stanza
+				endStanza()
+				isChorus = name == "start_of_chorus"
+				kind = sectionKind
+				label = value
+				continue
+			}
+
+			switch name {
+			case "end_of_chorus", "end_of_verse", "end_of_bridge", "end_of_tab", "end_of_grid":
+				stanzaEnded = true
+
+				//an empty section has no stanza to end, so the next stanza is not part of it
+				if len(lines) == 0 {
+					isChorus = false
+					kind = StanzaVerse
+					label = ""
+					stanzaEnded = false
+				}
+			case "chorus":
+				endStanza()
+				songStarted = true
+				stanzas = append(stanzas, Stanza{
+					IsChorus:       true,
+					Recall:         true,
+					Label:          value,
+					Number:         stanzaCount,
+					NewPage:        newPage,
+					ColumnBreak:    columnBreak,
+					ShowNumber:     stanzaShowNum,
+					BeforeComments: stanzaBeforeComments,
+					AfterComments:  make([]Comment, 0)})
+				newPage = false
+				columnBreak = false
+				stanzaShowNum = true
+				stanzaBeforeComments = make([]Comment, 0)
+			case "new_page":
+				endStanza()
+				newPage = true
+			case "column_break":
+				endStanza()
+				columnBreak = true
+			case "title":
+				title = value
+				titleInferred = false
+			case "section":
+				section = value
+			case "key":
+				keyText = value
+				keyLine = lineNum
+			case "subtitle", "artist", "composer", "lyricist", "copyright",
+				"album", "year", "time", "tempo", "duration":
+				meta[name] = append(meta[name], value)
+			case "naming":
+				naming = ParseNaming(value)
+				if naming == NamingDefault {
+					report(SeverityWarning, 0, "unknown naming system %q", value)
+				}
+			case "capo":
+				capo, err = strconv.Atoi(value)
+				if err != nil || capo < 0 || capo > 11 {
+					report(SeverityWarning, 0, "bad capo %q, expected a fret from 0 to 11", value)
+					capo = 0
+				}
+			case "define":
+				name, shape, ok := parseDefine(value)
+				if !ok {
+					report(SeverityWarning, 0, "bad chord definition %q", value)
+				} else {
+					defines[name] = shape
+				}
+			case "no_number":
 				if !songStarted {
 					songStanzaNum = false
 				} else {
 					stanzaShowNum = false
 				}
-				continue
-			} else if i := strings.Index(command, "{echo"); i >= 0 {
-				//fall through
-			} else {
-				fmt.Println(filename)
-				fmt.Printf("Unknown tag: %s\n", line)
+			default:
+				//ChordPro reserves x_ for custom directives, these and any
+				//other meta data are kept as is
+				if isMeta || strings.HasPrefix(name, "x_") {
+					meta[name] = append(meta[name], value)
+				} else {
+					report(SeverityWarning, 1, "unknown tag %s", line)
+				}
+			}
+			continue
+		}
+
+		//tabs and grids are kept as written, including blank lines
+		if kind == StanzaTab || kind == StanzaGrid {
+			if !stanzaEnded {
+				songStarted = true
+				lines = append(lines, Line{Text: line, Chords: make([]Chord, 0), EchoIndex: -1})
 				continue
 			}
 		}
@@ -143,39 +309,35 @@ func ParseSongFile(filename string, transpose int) (*Song, error) {
 		//blank line separates stanzas
 		if len(line) == 0 {
 			songStarted = true
-
-			if len(lines) > 0 {
-				stanzas = append(stanzas, *&Stanza{
-					Lines:          lines,
-					Number:         stanzaCount,
-					IsChorus:       isChorus,
-					ShowNumber:     stanzaShowNum,
-					BeforeComments: stanzaBeforeComments,
-					AfterComments:  stanzaAfterComments})
-
-				//Choruses do not get stanza numbers
-				if !isChorus {
-					stanzaCount++
-				}
-
-				isChorus = false
-				stanzaShowNum = true
-				lines = make([]Line, 0)
-				stanzaBeforeComments = make([]string, 0)
-				stanzaAfterComments = make([]string, 0)
-			}
+			endStanza()
 		} else {
 			songStarted = true
+			//text after the end of a section starts a new stanza
+			if stanzaEnded {
+				endStanza()
+			}
+
 			//check for echo marker
+			raw := line
+			echoStart, echoEnd := -1, -1
 			if i := strings.Index(line, "{echo:"); i >= 0 {
-				end := strings.Index(line, "}")
+				end := strings.Index(line[i:], "}")
 
-				if end < 1 {
-					fmt.Printf("Bad echo tag: %s\n", line)
+				if end < 0 {
+					report(SeverityError, lineColumn(raw, i), "unterminated echo tag")
+					echoStart, echoEnd = i, i+1
 				} else {
-					//to work out the index we have to remove the chords
+					end += i
+					echoStart, echoEnd = i, end+1
+
+					//to work out the index we have to remove the chords,
+					//which may have had the echo tag in them
 					clean := chordRegex.ReplaceAllString(line, "")
-					echo = strings.Index(clean, "{echo:")
+					if j := strings.Index(clean, "{echo:"); j >= 0 {
+						echo = utf8.RuneCountInString(clean[0:j])
+					} else {
+						report(SeverityError, lineColumn(raw, i), "echo tag inside a chord")
+					}
 
 					echoTxt := line[i+len("{echo:") : end]
 					echoTxt = strings.TrimSpace(echoTxt)
@@ -188,34 +350,33 @@ func ParseSongFile(filename string, transpose int) (*Song, error) {
 				}
 			}
 
+			//chord positions count the characters before them, without the chords
 			chordsPos := chordRegex.FindAllStringIndex(line, -1)
 			chordLen := 0
 			chords := make([]Chord, 0)
 
 			for _, pos := range chordsPos {
-				chordText := line[pos[0]+1 : pos[1]-1]
-				chordLen += pos[1] - pos[0]
-				position := pos[1] - chordLen
+				chordText := strings.TrimSpace(line[pos[0]+1 : pos[1]-1])
+				position := utf8.RuneCountInString(line[0:pos[0]]) - chordLen
+				chordLen += utf8.RuneCountInString(line[pos[0]:pos[1]])
 
-				chords = append(chords, Chord{text: chordText, Position: position, Transpose: transpose})
+				chords = append(chords, newNamedChord(chordText, position, transpose, naming))
 			}
 
 			//remove all chord markers
 			line = chordRegex.ReplaceAllString(line, "")
 			lines = append(lines, Line{Text: line, Chords: chords, EchoIndex: echo})
 
-			//check for bad commands
-			for _, pos := range badCommandRegex.FindAllStringIndex(line, -1) {
-				fmt.Println(filename)
-				fmt.Println(line)
-				for i := 0; i < pos[0]; i++ {
-					fmt.Print(" ")
+			//check for bad commands, i.e. any braces other than the echo tag
+			for _, pos := range badCommandRegex.FindAllStringIndex(raw, -1) {
+				if pos[0] < echoStart || pos[0] >= echoEnd {
+					report(SeverityError, lineColumn(raw, pos[0]), "unexpected %q", raw[pos[0]:pos[1]])
 				}
-				fmt.Println("^")
 			}
 
 			//Default title is first line text
 			if len(title) == 0 {
+				titleInferred = true
 				//Replace all quotation marks in title
 				title = line
 				re := regexp.MustCompile("[\"“”]")
@@ -223,13 +384,13 @@ func ParseSongFile(filename string, transpose int) (*Song, error) {
 				title = strings.TrimSpace(title)
 
 				//trim any trailing/leading puncutation
-				startReg := regexp.MustCompile("^[a-zA-Z0-9]")
-				endReg := regexp.MustCompile("[a-zA-Z0-9]$")
+				startReg := regexp.MustCompile(`^[\pL\pN]`)
+				endReg := regexp.MustCompile(`[\pL\pN]$`)
 				startDone := false
 				endDone := false
 
 				for {
-					if len(title) == 1 {
+					if utf8.RuneCountInString(title) <= 1 {
 						break
 					}
 
@@ -237,7 +398,8 @@ func ParseSongFile(filename string, transpose int) (*Song, error) {
 
 					//No match, title has punctuation at the start
 					if !startDone && len(pos) == 0 {
-						title = title[1:]
+						_, size := utf8.DecodeRuneInString(title)
+						title = title[size:]
 					} else {
 						startDone = true
 					}
@@ -245,7 +407,8 @@ func ParseSongFile(filename string, transpose int) (*Song, error) {
 					pos = endReg.FindAllStringIndex(title, 1)
 
 					if !endDone && len(pos) == 0 {
-						title = title[0 : len(title)-1]
+						_, size := utf8.DecodeLastRuneInString(title)
+						title = title[0 : len(title)-size]
 					} else {
 						endDone = true
 					}
@@ -259,37 +422,199 @@ func ParseSongFile(filename string, transpose int) (*Song, error) {
 	}
 
 	//check for last stanza
-	if len(lines) > 0 {
-		stanzas = append(stanzas, Stanza{
-			Lines:          lines,
-			Number:         stanzaCount,
-			IsChorus:       isChorus,
-			ShowNumber:     stanzaShowNum,
-			BeforeComments: stanzaBeforeComments,
-			AfterComments:  stanzaAfterComments})
-	} else if len(stanzaBeforeComments) > 0 {
+	if len(lines) == 0 && len(stanzaBeforeComments) > 0 {
 		songAfterComments = stanzaBeforeComments
 	}
+	endStanza()
+
+	song := &Song{
+		Filename:          filename,
+		Title:             title,
+		titleInferred:     titleInferred,
+		Section:           section,
+		StanzaCount:       0,
+		SongNumber:        -1,
+		ShowStanzaNumbers: songStanzaNum,
+		Stanzas:           stanzas,
+		BeforeComments:    songBeforeComments,
+		AfterComments:     songAfterComments,
+		UseLiberationFont: useLibFont,
+		Capo:              capo,
+		Naming:            naming,
+		Defines:           defines,
+		Subtitles:         meta["subtitle"],
+		Artists:           meta["artist"],
+		Composers:         meta["composer"],
+		Lyricists:         meta["lyricist"],
+		Copyright:         lastValue(meta["copyright"]),
+		Album:             lastValue(meta["album"]),
+		Year:              lastValue(meta["year"]),
+		Time:              lastValue(meta["time"]),
+		Tempo:             lastValue(meta["tempo"]),
+		Duration:          lastValue(meta["duration"]),
+		Meta:              meta}
+
+	for _, name := range []string{"subtitle", "artist", "composer", "lyricist", "copyright",
+		"album", "year", "time", "tempo", "duration"} {
+		delete(meta, name)
+	}
+
+	if len(keyText) > 0 {
+		var ok bool
+		song.Key, ok = parseKeyName(keyText, naming)
+		if !ok {
+			lineNum = keyLine
+			report(SeverityWarning, 0, "unknown key %q", keyText)
+		}
+	}
+
+	if !song.Key.IsValid() {
+		song.Key = song.inferKey()
+		song.keyInferred = true
+	}
+
+	song.Transpose(transpose)
+	song.SetCapo(capo, false)
+
+	return song, diags, scanner.Err()
+}
+
+//lineColumn returns the column (counting characters from 1) of the byte
+//at index <i> of <line>.
+func lineColumn(line string, i int) int {
+	return utf8.RuneCountInString(line[0:i]) + 1
+}
+
+//directiveAliases maps the short forms of ChordPro directives to their
+//full names. {comments} is this songbook's original comment tag.
+var directiveAliases = map[string]string{
+	"t":        "title",
+	"st":       "subtitle",
+	"c":        "comment",
+	"comments": "comment",
+	"ci":       "comment_italic",
+	"cb":       "comment_box",
+	"soc":      "start_of_chorus",
+	"eoc":      "end_of_chorus",
+	"sov":      "start_of_verse",
+	"eov":      "end_of_verse",
+	"sob":      "start_of_bridge",
+	"eob":      "end_of_bridge",
+	"sot":      "start_of_tab",
+	"eot":      "end_of_tab",
+	"sog":      "start_of_grid",
+	"eog":      "end_of_grid",
+	"np":       "new_page",
+	"colb":     "column_break",
+}
+
+var commentStyles = map[string]CommentStyle{
+	"comment":        CommentNormal,
+	"comment_italic": CommentItalic,
+	"comment_box":    CommentBox,
+}
+
+//sectionKinds maps the section names used in start_of_<section>
+//directives to the StanzaKind they start.
+var sectionKinds = map[string]StanzaKind{
+	"chorus": StanzaVerse,
+	"verse":  StanzaVerse,
+	"bridge": StanzaBridge,
+	"tab":    StanzaTab,
+	"grid":   StanzaGrid,
+}
+
+//parseDirective parses a ChordPro directive line, e.g. "{c: Repeat}",
+//into its lower case name (with any short form expanded) and value.
+func parseDirective(line string) (name string, value string) {
+	inner := strings.TrimPrefix(line, "{")
+	if end := strings.LastIndex(inner, "}"); end >= 0 {
+		inner = inner[0:end]
+	}
+
+	//the value follows a colon, or a space
+	i := strings.IndexAny(inner, ": \t")
+	if i < 0 {
+		name = inner
+	} else {
+		name, value = inner[0:i], strings.TrimSpace(inner[i+1:])
+	}
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	if full, ok := directiveAliases[name]; ok {
+		name = full
+	}
+
+	return name, value
+}
+
+//splitDirective splits the value of a {meta} directive, e.g. "artist Bach",
+//into the meta data name and its value.
+func splitDirective(meta string) (name string, value string) {
+	fields := strings.SplitN(strings.TrimSpace(meta), " ", 2)
+	name = strings.ToLower(fields[0])
+	if full, ok := directiveAliases[name]; ok {
+		name = full
+	}
 
-	return &Song{
-			Filename:          filename,
-			Title:             title,
-			Section:           section,
-			StanzaCount:       0,
-			SongNumber:        -1,
-			ShowStanzaNumbers: songStanzaNum,
-			Stanzas:           stanzas,
-			BeforeComments:    songBeforeComments,
-			AfterComments:     songAfterComments,
-			UseLiberationFont: useLibFont,
-			transpose:         transpose},
-		nil
+	if len(fields) > 1 {
+		value = strings.TrimSpace(fields[1])
+	}
+
+	return name, value
+}
+
+//lastValue returns the last of the given values, "" if there are none.
+func lastValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[len(values)-1]
+}
+
+//scanSongLines is a bufio.SplitFunc that splits song files into lines.
+//We need to handle /r only as Mac OS <= 9 uses this as end-of-line marker
+//This is based on bufio/scan.go ScanLines function
+func scanSongLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	i := bytes.IndexByte(data, '\n')
+
+	if i < 0 {
+		i = bytes.IndexByte(data, '\r')
+	}
+
+	ind := 0
+	if i > 0 && data[i-1] == '\r' {
+		ind = -1
+	}
+
+	if i >= 0 {
+
+		// We have a full newline-terminated line.
+		return i + 1, data[0 : i+ind], nil
+	}
+	// If we're at EOF, we have a final, non-terminated line. Return it.
+	if atEOF {
+		return len(data), data[0 : len(data)+ind], nil
+	}
+	// Request more data.
+	return 0, nil, nil
 }
 
 //parseCommand parses a given command string and strips off the framing characters.
 //i.e. given "{command: setting}", it will return "setting"
+//A command without a setting, or without the closing brace, returns "".
 func parseCommand(command string) string {
-	return strings.TrimSpace(command[strings.Index(command, ":")+1 : strings.Index(command, "}")])
+	start := strings.Index(command, ":")
+	end := strings.Index(command, "}")
+	if start < 0 || end < start {
+		return ""
+	}
+
+	return strings.TrimSpace(command[start+1 : end])
 }
 
 //GetTranspose returns the current chord transposition setting for this Song.
@@ -300,16 +625,258 @@ func (song Song) GetTranspose() int {
 	return song.transpose
 }
 
-//Transpose will iterate through all Chords contained in this song and call
-//Chord.Transpose(changeBy) on each one.
+//Transpose will iterate through all Chords contained in this song and set
+//their Transpose to changeBy, along with the Key they will be displayed in.
 func (song *Song) Transpose(changeBy int) {
+	song.setTranspose(changeBy, song.Key.Transpose(changeBy))
+}
+
+//TransposeToKey transposes this Song so that it is played in the given Key.
+//Only the tonic of the given Key is used, the Song stays major or minor.
+//Nothing is changed if the Song's Key is not known.
+func (song *Song) TransposeToKey(key Key) {
+	if !song.Key.IsValid() || !key.IsValid() {
+		return
+	}
+
+	key.Minor = song.Key.Minor
+	song.setTranspose(mod12(key.Tonic.Pitch()-song.Key.Tonic.Pitch()), key)
+}
+
+func (song *Song) setTranspose(changeBy int, key Key) {
 	song.transpose = changeBy
+	song.displayKey = key
+
+	song.forEachChord(func(chord *Chord) {
+		chord.Transpose = changeBy
+		chord.Key = key
+	})
+}
+
+//SetCapo sets the capo position used to display this Song's Chords.
+//With a capo the Chords are shown as the shapes to play, if showConcert is
+//true the sounding chords are shown alongside them.
+func (song *Song) SetCapo(capo int, showConcert bool) {
+	song.capo = mod12(capo)
+	song.showConcert = showConcert
+
+	song.forEachChord(func(chord *Chord) {
+		chord.Capo = song.capo
+		chord.ShowConcert = showConcert
+	})
+}
+
+//GetCapo returns the capo position this Song is displayed with,
+//0 if there is no capo.
+func (song Song) GetCapo() int {
+	return song.capo
+}
+
+//ShowsConcert returns true if the sounding chords are shown alongside the
+//capo shapes, false otherwise.
+func (song Song) ShowsConcert() bool {
+	return song.showConcert
+}
+
+//SetNotation sets the Notation used to display this Song's Chords.
+func (song *Song) SetNotation(notation Notation) {
+	song.notation = notation
+
+	song.forEachChord(func(chord *Chord) {
+		chord.Notation = notation
+	})
+}
+
+//GetNotation returns the Notation used to display this Song's Chords.
+func (song Song) GetNotation() Notation {
+	return song.notation
+}
+
+//SetNaming sets the NamingSystem used to display this Song's Chords,
+//NamingDefault displays them in the naming system they are written in.
+func (song *Song) SetNaming(naming NamingSystem) {
+	song.naming = naming
+
+	song.forEachChord(func(chord *Chord) {
+		chord.Naming = naming
+	})
+}
+
+//GetNaming returns the NamingSystem used to display this Song's Chords.
+func (song Song) GetNaming() NamingSystem {
+	return song.naming
+}
+
+//SetInstrument sets the Instrument chord diagrams are shown for,
+//nil shows no chord diagrams.
+func (song *Song) SetInstrument(instrument *Instrument) {
+	song.instrument = instrument
+}
+
+//GetInstrument returns the name of the Instrument chord diagrams are
+//shown for, or "" if no diagrams are shown.
+func (song Song) GetInstrument() string {
+	if song.instrument == nil {
+		return ""
+	}
+
+	return song.instrument.Name
+}
+
+//Diagrams returns a ChordDiagram for each different Chord in this Song,
+//in the order they are first played, for the Instrument set with
+//SetInstrument. Chords are drawn as played, i.e. after any transposition
+//and capo. Shapes given with {define} tags are used in preference to the
+//built-in shapes, chords with no known shape are left out.
+func (song Song) Diagrams() []ChordDiagram {
+	diagrams := make([]ChordDiagram, 0)
+	if song.instrument == nil {
+		return diagrams
+	}
+
+	seen := make(map[string]bool)
+	song.forEachChord(func(chord *Chord) {
+		name, ok := chord.playedName()
+		if !ok {
+			return
+		}
 
+		label := name.render(0, Key{}, chord.Naming)
+		if seen[label] {
+			return
+		}
+		seen[label] = true
+
+		shape, ok := song.Defines[name.render(0, Key{}, NamingDefault)]
+		if !ok || len(shape.Frets) != len(song.instrument.Tuning) {
+			shape, ok = song.instrument.Shape(name.root, name.quality)
+		}
+
+		if ok {
+			diagrams = append(diagrams, ChordDiagram{Name: label, Shape: shape})
+		}
+	})
+
+	return diagrams
+}
+
+//KeyName returns the name of the given Key in the NamingSystem this Song
+//is displayed with.
+func (song Song) KeyName(key Key) string {
+	naming := song.naming
+	if naming == NamingDefault {
+		naming = song.Naming
+	}
+
+	return key.Name(naming)
+}
+
+//DisplayKey returns the Key this Song is currently displayed in,
+//i.e. the Song's Key after any transposition.
+func (song Song) DisplayKey() Key {
+	return song.displayKey
+}
+
+//IsTransposed returns true if this Song is displayed in a different
+//key to the one it is written in, false otherwise.
+func (song Song) IsTransposed() bool {
+	return song.transpose%12 != 0
+}
+
+//KeyChoices returns the twelve keys this Song can be transposed to,
+//starting from C, spelled as they would be for a transposition.
+func (song Song) KeyChoices() []Key {
+	if !song.Key.IsValid() {
+		return nil
+	}
+
+	keys := make([]Key, 12)
+	for i := range keys {
+		keys[i] = song.Key.Transpose(i - song.Key.Tonic.Pitch())
+	}
+
+	return keys
+}
+
+//inferKey guesses the key of this Song from its Chords.
+//Each candidate key scores points for every Chord that belongs to it, with
+//extra weight given to the tonic chord and to the first and last Chords,
+//as songs usually start and end on the tonic.
+//Returns the zero Key if the Song has no recognisable Chords.
+func (song Song) inferKey() Key {
+	chords := make([]Chord, 0)
+	song.forEachChord(func(chord *Chord) {
+		if chord.Root.IsValid() {
+			chords = append(chords, *chord)
+		}
+	})
+
+	if len(chords) == 0 {
+		return Key{}
+	}
+
+	var best Key
+	bestScore := -1
+
+	for _, minor := range []bool{false, true} {
+		for pitch := 0; pitch < 12; pitch++ {
+			key := Key{Tonic: majorKeys[pitch], Minor: minor}
+			if minor {
+				key.Tonic = minorKeys[pitch]
+			}
+
+			score := 0
+			for _, c := range chords {
+				score += key.chordScore(c)
+			}
+
+			score += 2 * key.chordScore(chords[0])
+			score += 2 * key.chordScore(chords[len(chords)-1])
+
+			if score > bestScore {
+				best = key
+				bestScore = score
+			}
+		}
+	}
+
+	//prefer the song's own spelling, e.g. C# rather than Db
+	for _, c := range chords {
+		if c.Root.Pitch() == best.Tonic.Pitch() {
+			best.Tonic = c.Root
+			break
+		}
+	}
+
+	return best
+}
+
+//Copy returns a copy of this Song that can be transposed (or have any other
+//display setting changed) without changing this Song.
+func (song Song) Copy() Song {
+	stanzas := make([]Stanza, len(song.Stanzas))
+	for i, s := range song.Stanzas {
+		lines := make([]Line, len(s.Lines))
+		for j, l := range s.Lines {
+			l.Chords = append([]Chord(nil), l.Chords...)
+			lines[j] = l
+		}
+		s.Lines = lines
+		stanzas[i] = s
+	}
+	song.Stanzas = stanzas
+
+	return song
+}
+
+//forEachChord calls fn with a pointer to each Chord in this Song,
+//so that the Chords can be modified.
+func (song *Song) forEachChord(fn func(chord *Chord)) {
 	for _, s := range song.Stanzas {
 		for _, l := range s.Lines {
 			//index range here because we are modifying the Chord
 			for i := range l.Chords {
-				l.Chords[i].Transpose = changeBy
+				fn(&l.Chords[i])
 			}
 		}
 	}
@@ -345,6 +912,28 @@ func (song Song) String() string {
 	return buffer.String()
 }
 
+//Byline returns the Song's artists, lyricists and composers as a single
+//line of text, e.g. "Words by Isaac Watts, music by Lowell Mason".
+func (song Song) Byline() string {
+	parts := make([]string, 0)
+	if len(song.Artists) > 0 {
+		parts = append(parts, strings.Join(song.Artists, ", "))
+	}
+	if len(song.Lyricists) > 0 {
+		parts = append(parts, "words by "+strings.Join(song.Lyricists, ", "))
+	}
+	if len(song.Composers) > 0 {
+		parts = append(parts, "music by "+strings.Join(song.Composers, ", "))
+	}
+
+	line := strings.Join(parts, ", ")
+	if len(line) > 0 {
+		line = strings.ToUpper(line[0:1]) + line[1:]
+	}
+
+	return line
+}
+
 //HasBeforeComments returns true if this Song has any BeforeComments set,
 //false otherwise.
 func (song Song) HasBeforeComments() bool {
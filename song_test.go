@@ -0,0 +1,291 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+//songWithChords creates a single-line Song containing the given chords.
+func songWithChords(chords ...string) Song {
+	line := Line{EchoIndex: -1}
+	for i, c := range chords {
+		line.Chords = append(line.Chords, NewChord(c, i, 0))
+	}
+
+	return Song{Stanzas: []Stanza{Stanza{Lines: []Line{line}}}}
+}
+
+var inferKeyTests = []struct {
+	in       Song
+	expected string
+}{
+	{songWithChords("G", "C", "D", "G"), "G"},
+	{songWithChords("C", "F", "G7", "C"), "C"},
+	{songWithChords("Am", "Dm", "E7", "Am"), "Am"},
+	{songWithChords("Eb", "Ab", "Bb7", "Cm", "Eb"), "Eb"},
+	{songWithChords("C#m", "A", "E", "B", "C#m"), "C#m"},
+	{songWithChords("D/F#", "G", "A", "D"), "D"},
+	{songWithChords("N.C."), ""},
+	{Song{}, ""},
+}
+
+func TestInferKey(t *testing.T) {
+	for i, ct := range inferKeyTests {
+		actual := ct.in.inferKey().String()
+
+		if actual != ct.expected {
+			t.Errorf("Song(%d), expected %v, actual %v", i, ct.expected, actual)
+		}
+	}
+}
+
+func TestTransposeToKey(t *testing.T) {
+	song := songWithChords("G", "C", "D7", "Em")
+	song.Key, _ = ParseKey("G")
+
+	song.TransposeToKey(Key{Tonic: Note{'E', -1}})
+
+	if song.GetTranspose() != 8 {
+		t.Errorf("expected transpose 8, actual %d", song.GetTranspose())
+	}
+
+	expected := []string{"Eb", "Ab", "Bb7", "Cm"}
+	for i, c := range song.Stanzas[0].Lines[0].Chords {
+		if c.GetText() != expected[i] {
+			t.Errorf("Chord(%d), expected %v, actual %v", i, expected[i], c.GetText())
+		}
+	}
+}
+
+func TestDiagrams(t *testing.T) {
+	song := songWithChords("A", "D", "A", "Asus4", "N.C.")
+	song.Key, _ = ParseKey("A")
+	song.Defines = map[string]ChordShape{"Asus4": ChordShape{Frets: []int{X, 0, 2, 2, 3, 3}}}
+	song.Transpose(0)
+	song.SetInstrument(Guitar)
+
+	diagrams := song.Diagrams()
+	expected := []string{"A", "D", "Asus4"}
+	if len(diagrams) != len(expected) {
+		t.Fatalf("expected %d diagrams, actual %d", len(expected), len(diagrams))
+	}
+	for i, d := range diagrams {
+		if d.Name != expected[i] {
+			t.Errorf("diagram %d, expected %s, actual %s", i, expected[i], d.Name)
+		}
+	}
+	if diagrams[2].Shape.Frets[5] != 3 {
+		t.Errorf("expected the defined Asus4 shape, actual %v", diagrams[2].Shape.Frets)
+	}
+
+	//with a capo on 2 the shapes are played a tone lower
+	song.SetCapo(2, false)
+	if d := song.Diagrams(); d[0].Name != "G" || d[1].Name != "C" {
+		t.Errorf("expected G and C shapes, actual %s and %s", d[0].Name, d[1].Name)
+	}
+}
+
+//parseSongText parses the given .song file text.
+func parseSongText(t *testing.T, text string) *Song {
+	song, _, err := ParseSong(strings.NewReader(text), ParseOptions{Filename: "test.song"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return song
+}
+
+func TestParseChordProDirectives(t *testing.T) {
+	song := parseSongText(t, `{t: Amazing Grace}
+{st: New Britain}
+{artist: Traditional}
+{meta: composer William Walker}
+{lyricist: John Newton}
+{copyright: Public domain}
+{time: 3/4}
+{tempo: 90}
+{meta: arranger Someone}
+{c: Slowly}
+
+{start_of_verse: Verse 1}
+A[G]mazing grace
+{ci: Repeat}
+{end_of_verse}
+{soc}
+[C]How sweet the sound
+{eoc}
+
+{cb: Key change}
+{chorus}
+{np}
+{sot}
+e|---0---|
+
+B|---1---|
+{eot}
+{start_of_bridge}
+That saved a wretch
+{end_of_bridge}
+`)
+
+	if song.Title != "Amazing Grace" || len(song.Subtitles) != 1 || song.Subtitles[0] != "New Britain" {
+		t.Errorf("expected title and subtitle, actual %q %v", song.Title, song.Subtitles)
+	}
+	if song.Byline() != "Traditional, words by John Newton, music by William Walker" {
+		t.Errorf("unexpected byline %q", song.Byline())
+	}
+	if song.Copyright != "Public domain" || song.Time != "3/4" || song.Tempo != "90" {
+		t.Errorf("unexpected meta data %q %q %q", song.Copyright, song.Time, song.Tempo)
+	}
+	if len(song.Meta["arranger"]) != 1 {
+		t.Errorf("expected arranger meta data, actual %v", song.Meta)
+	}
+	if len(song.BeforeComments) != 1 || song.BeforeComments[0].Text != "Slowly" {
+		t.Errorf("unexpected song comments %v", song.BeforeComments)
+	}
+
+	expected := []struct {
+		chorus, recall bool
+		kind           StanzaKind
+		label          string
+		lines          int
+		newPage        bool
+	}{
+		{false, false, StanzaVerse, "Verse 1", 1, false},
+		{true, false, StanzaVerse, "", 1, false},
+		{true, true, StanzaVerse, "", 0, false},
+		{false, false, StanzaTab, "", 3, true},
+		{false, false, StanzaBridge, "", 1, false},
+	}
+
+	if len(song.Stanzas) != len(expected) {
+		t.Fatalf("expected %d stanzas, actual %d", len(expected), len(song.Stanzas))
+	}
+
+	for i, e := range expected {
+		s := song.Stanzas[i]
+		if s.IsChorus != e.chorus || s.Recall != e.recall || s.Kind != e.kind ||
+			s.Label != e.label || len(s.Lines) != e.lines || s.NewPage != e.newPage {
+			t.Errorf("stanza %d, expected %+v, actual %+v", i, e, s)
+		}
+	}
+
+	if c := song.Stanzas[0].AfterComments; len(c) != 1 || c[0].Style != CommentItalic {
+		t.Errorf("expected an italic comment after verse 1, actual %v", c)
+	}
+	if c := song.Stanzas[2].BeforeComments; len(c) != 1 || c[0].Style != CommentBox {
+		t.Errorf("expected a boxed comment before the chorus recall, actual %v", c)
+	}
+	if l := song.Stanzas[3].Lines[0]; l.Text != "e|---0---|" || l.HasChords() {
+		t.Errorf("expected tab lines as written, actual %+v", l)
+	}
+}
+
+func TestParseEmptySections(t *testing.T) {
+	for _, section := range []string{"chorus", "verse", "bridge", "tab", "grid"} {
+		song := parseSongText(t, "{start_of_"+section+": Empty}\n{end_of_"+section+"}\nverse line\n\n{soc}\n\nchorus line\n{eoc}\n")
+
+		if len(song.Stanzas) != 2 {
+			t.Errorf("%s: expected 2 stanzas, actual %v", section, song.Stanzas)
+			continue
+		}
+		if s := song.Stanzas[0]; s.IsChorus || s.Kind != StanzaVerse || len(s.Label) > 0 || s.Lines[0].Text != "verse line" {
+			t.Errorf("%s: expected a verse after the empty section, actual %+v", section, s)
+		}
+		if s := song.Stanzas[1]; !s.IsChorus || s.Lines[0].Text != "chorus line" {
+			t.Errorf("%s: expected a chorus, actual %+v", section, s)
+		}
+	}
+}
+
+var diagnosticTests = []struct {
+	in       string
+	expected []string
+}{
+	{"{title: Fine}\n\nA [G]line {echo: a line}", []string{}},
+	{"{bogus: tag}", []string{"test.song:1:1: warning: unknown tag {bogus: tag}"}},
+	{"{x_custom: ignored}", []string{}},
+	{"{capo: 13}\n{key: Q}\nA line", []string{
+		"test.song:1: warning: bad capo \"13\", expected a fret from 0 to 11",
+		"test.song:2: warning: unknown key \"Q\""}},
+	{"Line one\nÄ [G]line with } brace", []string{"test.song:2:16: error: unexpected \"}\""}},
+	{"A line {echo: unterminated", []string{"test.song:1:8: error: unterminated echo tag"}},
+	{"a [x {echo: y] z}", []string{"test.song:1:6: error: echo tag inside a chord"}},
+}
+
+func TestParseSongDiagnostics(t *testing.T) {
+	for _, dt := range diagnosticTests {
+		_, diags, err := ParseSong(strings.NewReader(dt.in), ParseOptions{Filename: "test.song"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(diags) != len(dt.expected) {
+			t.Errorf("%q: expected %v, actual %v", dt.in, dt.expected, diags)
+			continue
+		}
+
+		for i, d := range diags {
+			if d.String() != dt.expected[i] {
+				t.Errorf("%q: expected %s, actual %s", dt.in, dt.expected[i], d)
+			}
+		}
+	}
+}
+
+func TestParseSongOptions(t *testing.T) {
+	song, _, _ := ParseSong(strings.NewReader("[H]Line [B]one"),
+		ParseOptions{Filename: "german.song", Transpose: 2, Naming: NamingGerman})
+
+	if song.Filename != "german.song" {
+		t.Errorf("expected filename german.song, actual %s", song.Filename)
+	}
+
+	chords := song.Stanzas[0].Lines[0].Chords
+	if chords[0].GetText() != "Db" || chords[1].GetText() != "C" {
+		t.Errorf("expected Db and C, actual %s and %s", chords[0].GetText(), chords[1].GetText())
+	}
+}
+
+//the text before a chord starts after the previous chord's own text
+var runePositionTests = []struct {
+	in     string
+	title  string
+	text   string
+	chords []string
+	echo   string
+}{
+	{"[G]Amazing [D]grace", "Amazing grace", "Amazing grace", []string{"", "mazing "}, ""},
+	{"‘Tis [G]so sweet", "Tis so sweet", "‘Tis so sweet", []string{"‘Tis "}, ""},
+	{"Whakaaria [G]mai tōu [C]rīpeka {echo: [D]ki ā-au}", "Whakaaria mai tōu rīpeka ki ā-au",
+		"Whakaaria mai tōu rīpeka ki ā-au", []string{"Whakaaria ", "ai tōu ", "īpeka "}, "ki ā-au"},
+	{"“Ōku [Am]hoa…”", "Ōku hoa", "“Ōku hoa…”", []string{"“Ōku "}, ""},
+	{"a [x {echo: y] z}", "a  z", "a  z", []string{"a "}, ""},
+}
+
+func TestParseSongRunePositions(t *testing.T) {
+	for _, rt := range runePositionTests {
+		song := parseSongText(t, rt.in)
+		line := song.Stanzas[0].Lines[0]
+
+		if song.Title != rt.title {
+			t.Errorf("%q: expected title %q, actual %q", rt.in, rt.title, song.Title)
+		}
+		if line.Text != rt.text {
+			t.Errorf("%q: expected text %q, actual %q", rt.in, rt.text, line.Text)
+		}
+		if line.EchoText() != rt.echo {
+			t.Errorf("%q: expected echo %q, actual %q", rt.in, rt.echo, line.EchoText())
+		}
+
+		if len(line.Chords) != len(rt.chords) {
+			t.Errorf("%q: expected %d chords, actual %d", rt.in, len(rt.chords), len(line.Chords))
+			continue
+		}
+		for i, c := range line.Chords {
+			if actual := line.PreChordText(c); actual != rt.chords[i] {
+				t.Errorf("%q: chord %d, expected %q before it, actual %q", rt.in, i, rt.chords[i], actual)
+			}
+		}
+	}
+}
@@ -3,11 +3,13 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 //Structs used when parsing a song file
@@ -16,6 +18,7 @@ type Songbook struct {
 	UseSection    bool
 	IndexChorus   bool
 	IndexPosition int
+	Naming        NamingSystem
 	Filename      string
 	Title         string
 	Songs         map[int]Song
@@ -40,22 +43,37 @@ const (
 )
 
 func ParseSongbookFile(filename string, songs_root string) (*Songbook, error) {
-	file, err := os.Open(filename)
-
-	filename = filepath.Base(filename)
-	title := filename[0 : len(filename)-len(".songlist")]
+	return parseSongbookFile(filename, func(song string) (*Song, error) {
+		return ParseSongFile(songs_root+"/"+song, 0)
+	})
+}
 
+//parseSongbookFile reads the Songbook from the given .songlist file, using
+//<loadSong> to read each song file named in it (e.g. "amazing.song").
+//The Songs returned by loadSong are changed, so must not be shared.
+func parseSongbookFile(filename string, loadSong func(song string) (*Song, error)) (*Songbook, error) {
+	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
+	return parseSongbook(file, filepath.Base(filename), loadSong)
+}
+
+//parseSongbook reads the Songbook in .songlist format from <r>, see
+//parseSongbookFile. Filename is the name of the .songlist file.
+func parseSongbook(r io.Reader, filename string, loadSong func(song string) (*Song, error)) (*Songbook, error) {
+	title := filename[0 : len(filename)-len(".songlist")]
+
 	var (
-		scanner     = bufio.NewScanner(file)
+		err         error
+		scanner     = bufio.NewScanner(r)
 		fixed_order = false
 		use_section = false
 		use_chorus  = false
 		index_pos   = IndexNone
+		naming      = NamingDefault
 		songs       = make(map[int]Song)
 	)
 
@@ -79,6 +97,9 @@ func ParseSongbookFile(filename string, songs_root string) (*Songbook, error) {
 			} else if strings.HasPrefix(command, "{index_use_chorus}") {
 				use_chorus = true
 				continue
+			} else if strings.HasPrefix(command, "{naming:") {
+				naming = ParseNaming(parseCommand(line))
+				continue
 			} else if strings.HasPrefix(command, "{index_position:") {
 				p := parseCommand(line)
 
@@ -96,7 +117,7 @@ func ParseSongbookFile(filename string, songs_root string) (*Songbook, error) {
 
 				continue
 			} else {
-				fmt.Printf("Unknown tag: %s\n", line)
+				fmt.Fprintf(os.Stderr, "Unknown tag: %s\n", line)
 				continue
 			}
 		}
@@ -106,6 +127,14 @@ func ParseSongbookFile(filename string, songs_root string) (*Songbook, error) {
 		//ignore blank lines
 		if len(line) > 0 {
 			num := -1
+
+			//check for a key to play the song in, i.e. "song {key: A}"
+			var key Key
+			if i := strings.Index(strings.ToLower(line), "{key:"); i > 0 {
+				key, _ = ParseKey(parseCommand(line[i:]))
+				line = strings.TrimSpace(line[0:i])
+			}
+
 			//check for fixed numbering
 			if strings.Index(line, ",") > 0 {
 				num_str := line[0:strings.Index(line, ",")]
@@ -122,20 +151,27 @@ func ParseSongbookFile(filename string, songs_root string) (*Songbook, error) {
 				line += ".song"
 			}
 
-			song, err := ParseSongFile(songs_root+"/"+line, 0)
+			song, err := loadSong(line)
 
 			if err != nil {
-				fmt.Println(num, ":", err)
+				fmt.Fprintln(os.Stderr, num, ":", err)
 			} else {
 				if num < 0 {
 					num = len(songs) + 1
 				}
 				song.SongNumber = num
+				song.TransposeToKey(key)
 				songs[num] = *song
 			}
 		}
 	}
 
+	//naming applies to every song, wherever the tag appears
+	for num, song := range songs {
+		song.SetNaming(naming)
+		songs[num] = song
+	}
+
 	return &Songbook{
 			Title:         title,
 			FixedOrder:    fixed_order,
@@ -143,10 +179,100 @@ func ParseSongbookFile(filename string, songs_root string) (*Songbook, error) {
 			UseSection:    use_section,
 			IndexChorus:   use_chorus,
 			IndexPosition: index_pos,
+			Naming:        naming,
 			Songs:         songs},
 		nil
 }
 
+//songlistSongName returns where the song's file name is in a line of a
+//songlist (as parseSongbook reads it, i.e. without any number or key), or
+//false if the line does not name a song.
+func songlistSongName(line string) (int, int, bool) {
+	if strings.HasPrefix(line, "{") {
+		return 0, 0, false
+	}
+
+	start := len(line) - len(strings.TrimLeftFunc(line, unicode.IsSpace))
+	end := len(strings.TrimRightFunc(line, unicode.IsSpace))
+	if start >= end {
+		return 0, 0, false
+	}
+
+	if i := strings.Index(strings.ToLower(line[start:end]), "{key:"); i > 0 {
+		end = len(strings.TrimRightFunc(line[:start+i], unicode.IsSpace))
+	}
+
+	if i := strings.Index(line[start:end], ","); i > 0 {
+		if _, err := strconv.Atoi(line[start : start+i]); err == nil {
+			start += i + 1
+		}
+	}
+
+	return start, end, start < end
+}
+
+//validSonglistSong returns true if <song> can be written as a line of a
+//songlist: a song, optionally with the key to play it in, e.g. "a {key: G}",
+//and nothing that could be read as another line or tag.
+func validSonglistSong(song string) bool {
+	if strings.ContainsAny(song, "\r\n") {
+		return false
+	}
+
+	name := song
+	if i := strings.Index(strings.ToLower(song), "{key:"); i > 0 {
+		name = song[0:i]
+		key := strings.TrimSpace(song[i+len("{key:"):])
+		if !strings.HasSuffix(key, "}") || strings.ContainsAny(key[0:len(key)-1], "{}") {
+			return false
+		}
+	}
+
+	return !strings.ContainsAny(name, "{}")
+}
+
+//songlistHasSong returns true if the songlist <content> has the song (its
+//file name without ".song") in it.
+func songlistHasSong(content []byte, song string) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		if start, end, ok := songlistSongName(line); ok && strings.TrimSuffix(line[start:end], ".song") == song {
+			return true
+		}
+	}
+
+	return false
+}
+
+//renameInSonglist returns the songlist <content> with the song <from>
+//changed to the song <to> (file names without ".song"), keeping everything
+//else on its lines, and whether the song was in it.
+func renameInSonglist(content []byte, from string, to string) ([]byte, bool) {
+	lines := strings.SplitAfter(string(content), "\n")
+	changed := false
+
+	for i, line := range lines {
+		start, end, ok := songlistSongName(line)
+		if ok && strings.TrimSuffix(line[start:end], ".song") == from {
+			lines[i] = line[:start] + to + line[start+len(from):]
+			changed = true
+		}
+	}
+
+	return []byte(strings.Join(lines, "")), changed
+}
+
+//Copy returns a copy of this Songbook whose Songs can be changed (see
+//Song.Copy) without changing this Songbook.
+func (sbook Songbook) Copy() Songbook {
+	songs := make(map[int]Song, len(sbook.Songs))
+	for num, song := range sbook.Songs {
+		songs[num] = song.Copy()
+	}
+	sbook.Songs = songs
+
+	return sbook
+}
+
 func GetSongOrder(sbook *Songbook) (keys []int) {
 	keys = make([]int, len(sbook.Songs))
 	i := 0
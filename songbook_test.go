@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var renameInSonglistTests = []struct {
+	content  string
+	expected string
+	changed  bool
+}{
+	{"a\n", "z\n", true},
+	{"{title: a}\na.song\nb\n", "{title: a}\nz.song\nb\n", true},
+	{"  a  {key: G}\r\n", "  z  {key: G}\r\n", true},
+	{"3,a {KEY: A}\n2,b\n", "3,z {KEY: A}\n2,b\n", true},
+	{"ab\nb.a\nx,a\n{a}\n", "ab\nb.a\nx,a\n{a}\n", false},
+	{"b\na", "b\nz", true},
+	{"", "", false},
+}
+
+func TestRenameInSonglist(t *testing.T) {
+	for _, rt := range renameInSonglistTests {
+		actual, changed := renameInSonglist([]byte(rt.content), "a", "z")
+		if string(actual) != rt.expected || changed != rt.changed {
+			t.Errorf("renameInSonglist(%q): expected %q (%t), actual %q (%t)", rt.content, rt.expected, rt.changed, actual, changed)
+		}
+
+		if has := songlistHasSong([]byte(rt.content), "a"); has != rt.changed {
+			t.Errorf("songlistHasSong(%q): expected %t, actual %t", rt.content, rt.changed, has)
+		}
+	}
+}
+
+var validSonglistSongTests = []struct {
+	song     string
+	expected bool
+}{
+	{"a", true},
+	{"1,a.song {key: G}", true},
+	{"a {KEY: Bb }", true},
+	{"a {key: G", false},
+	{"a {key: G} {title: B}", false},
+	{"a}", false},
+	{"{title: a}", false},
+	{"a\n{title: b}", false},
+}
+
+func TestValidSonglistSong(t *testing.T) {
+	for _, vt := range validSonglistSongTests {
+		if actual := validSonglistSong(vt.song); actual != vt.expected {
+			t.Errorf("validSonglistSong(%q): expected %t, actual %t", vt.song, vt.expected, actual)
+		}
+	}
+}
+
+func TestParseSongbookUnterminated(t *testing.T) {
+	loadSong := func(file string) (*Song, error) {
+		return &Song{Title: file}, nil
+	}
+
+	//a tag without its closing brace is ignored, rather than read past the end
+	book, err := parseSongbook(strings.NewReader("{title: Book\na {key: G\nb {key: A}\n"), "book.songlist", loadSong)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if book.Title != "" || len(book.Songs) != 2 || book.Songs[1].Title != "a.song" || book.Songs[2].Title != "b.song" {
+		t.Errorf("expected songs a and b, actual %q %v", book.Title, book.Songs)
+	}
+}
@@ -64,6 +64,32 @@ var printFonts = BookFonts{
 	ChorusIndent:       (pStanzaSize * 0.75) * 2,
 }
 
+//defaultElectronicFonts and defaultPrintFonts keep the fonts above,
+//before any change by setPDFFonts.
+var defaultElectronicFonts = electronicFonts
+var defaultPrintFonts = printFonts
+
+//pdfPageSize is the page size of PDFs, e.g. "A4" or "Letter".
+var pdfPageSize = "A4"
+
+//pdfFontDir is the directory with the font files and maps.
+var pdfFontDir = "."
+
+//setPDFFonts sets the font family used for lyrics and comments (Times by
+//default), and for titles, numbers and chords (Helvetica by default).
+func setPDFFonts(font string, headingFont string) {
+	families := map[string]string{"Times": font, "Helvetica": headingFont}
+
+	electronicFonts = defaultElectronicFonts
+	printFonts = defaultPrintFonts
+	for _, fonts := range []*BookFonts{&electronicFonts, &printFonts} {
+		for _, f := range []*PDFFont{&fonts.Stanza, &fonts.SongNumber, &fonts.Chord, &fonts.Comment,
+			&fonts.Title, &fonts.Section, &fonts.TOC, &fonts.Index} {
+			f.Family = families[f.Family]
+		}
+	}
+}
+
 //WriteBookPDF and WriteBookPDFElectronic are similar, the difference is that
 //The electronic verion includes a hyper-linked index page
 //And prints one song per-page
@@ -139,15 +165,15 @@ func WriteBookPDF(sbook *Songbook) (*bytes.Buffer, error) {
 			y := pdf.GetY()
 
 			//two-column songs must start on col 0
-			if song.getHeight(pdf, printFonts) > height &&
+			if song.getHeight(pdf, printFonts, true) > height &&
 				(y > getSongStartY(pdf, false, printFonts) ||
 					crrntCol == 1) {
 				remaining_songs = append(remaining_songs, song)
 				continue
 			} else if y > getSongStartY(pdf, false, printFonts) {
-				if crrntCol == 0 && y+song.getHeight(pdf, printFonts) > height {
+				if crrntCol == 0 && y+song.getHeight(pdf, printFonts, true) > height {
 					nextCol(pdf, printFonts)
-				} else if crrntCol == 1 && y+song.getHeight(pdf, printFonts) > height {
+				} else if crrntCol == 1 && y+song.getHeight(pdf, printFonts, true) > height {
 					remaining_songs = append(remaining_songs, song)
 					continue
 				}
@@ -171,7 +197,7 @@ func WriteBookPDF(sbook *Songbook) (*bytes.Buffer, error) {
 
 func initPDF(title string) *gofpdf.Fpdf {
 	//Set up PDF object
-	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf := gofpdf.New("P", "mm", pdfPageSize, pdfFontDir)
 	pdf.AddPage()
 	pdf.SetDisplayMode("fullpage", "TwoColumnLeft")
 	pdf.SetTitle(title, true)
@@ -229,7 +255,11 @@ func printSong(pdf *gofpdf.Fpdf, song *Song, fonts BookFonts, two_columns bool)
 
 	//Print stanzas
 	for _, stanza := range song.Stanzas {
-		if (pdf.GetY() + stanza.getHeight(pdf, fonts)) >= height {
+		if stanza.NewPage || (stanza.ColumnBreak && !two_columns) {
+			newPage(pdf, fonts)
+		} else if stanza.ColumnBreak {
+			nextCol(pdf, fonts)
+		} else if (pdf.GetY() + stanza.getHeight(pdf, fonts)) >= height {
 			if two_columns {
 				nextCol(pdf, fonts)
 			} else {
@@ -241,10 +271,10 @@ func printSong(pdf *gofpdf.Fpdf, song *Song, fonts BookFonts, two_columns bool)
 			printSongNumber(pdf, tr, song.SongNumber, fonts)
 
 			//Print pre-song comments
-			printlnSlice(
+			printComments(
 				pdf,
 				tr,
-				song.BeforeComments,
+				song.getBeforeComments(),
 				fonts.Comment)
 			pdf.Ln(fonts.Comment.Height(pdf))
 
@@ -257,8 +287,13 @@ func printSong(pdf *gofpdf.Fpdf, song *Song, fonts BookFonts, two_columns bool)
 			setXAndMargin(pdf, xMargin+fonts.ChorusIndent)
 		}
 
+		//Print stanza label, e.g. "Verse 1" or "Chorus"
+		if len(stanza.GetLabel()) > 0 {
+			println(pdf, tr, stanza.GetLabel(), fonts.Comment)
+		}
+
 		//Print pre-stanza comments
-		printlnSlice(
+		printComments(
 			pdf,
 			tr,
 			stanza.BeforeComments,
@@ -276,7 +311,13 @@ func printSong(pdf *gofpdf.Fpdf, song *Song, fonts BookFonts, two_columns bool)
 				offset = fonts.ChorusIndent
 			}
 
-			print_stanza_number := song.ShowStanzaNumbers && ind == 0 && stanza.ShowNumber && !stanza.IsChorus
+			print_stanza_number := song.ShowStanzaNumbers && ind == 0 && stanza.IsNumbered()
+
+			//tabs and grids are printed as written
+			if stanza.IsVerbatim() {
+				println(pdf, tr, line.Text, fonts.verbatimFont())
+				continue
+			}
 
 			setFont(pdf, fonts.Stanza)
 			//check width
@@ -295,7 +336,7 @@ func printSong(pdf *gofpdf.Fpdf, song *Song, fonts BookFonts, two_columns bool)
 		}
 
 		//print post-stanza comments
-		printlnSlice(
+		printComments(
 			pdf,
 			tr,
 			stanza.AfterComments,
@@ -306,12 +347,14 @@ func printSong(pdf *gofpdf.Fpdf, song *Song, fonts BookFonts, two_columns bool)
 	setXAndMargin(pdf, xMargin+fonts.StanzaIndent)
 
 	//Print post-song comments
-	printlnSlice(
+	printComments(
 		pdf,
 		tr,
-		song.AfterComments,
+		song.getAfterComments(),
 		fonts.Comment)
 
+	printDiagrams(pdf, tr, song.Diagrams(), fonts, two_columns)
+
 	setXAndMargin(pdf, xMargin)
 
 	fonts.Stanza = stanzaFont
@@ -331,7 +374,7 @@ func printLine(pdf *gofpdf.Fpdf, tr func(string) string, stanza Stanza, line Lin
 		//to position chords correctly
 		if chord.Position > 0 {
 			setFont(pdf, fonts.Stanza)
-			w = pdf.GetStringWidth(tr(line.Text[last_pos:chord.Position]))
+			w = pdf.GetStringWidth(tr(line.Text[runeIndex(line.Text, last_pos):runeIndex(line.Text, chord.Position)]))
 			w -= last_chord_w
 			w -= adjust_w
 			pdf.Cell(w, fonts.Chord.Height(pdf), "")
@@ -365,19 +408,19 @@ func printLine(pdf *gofpdf.Fpdf, tr func(string) string, stanza Stanza, line Lin
 	//Print echo
 	if line.HasEcho() {
 		if line.EchoIndex > 0 {
-			str := line.Text[0:line.EchoIndex]
-			w = pdf.GetStringWidth(str)
+			str := line.PreEchoText()
+			w = pdf.GetStringWidth(tr(str))
 			pdf.Cell(w, fonts.Stanza.Height(pdf), tr(str))
 		}
 
 		pdf.SetTextColor(128, 128, 128)
-		str := line.Text[line.EchoIndex:len(line.Text)]
+		str := line.EchoText()
 
-		w = pdf.GetStringWidth(str)
+		w = pdf.GetStringWidth(tr(str))
 		pdf.Cell(w, fonts.Stanza.Height(pdf), tr(str))
 		pdf.SetTextColor(0, 0, 0)
 	} else {
-		w = pdf.GetStringWidth(line.Text)
+		w = pdf.GetStringWidth(tr(line.Text))
 		pdf.Cell(w, fonts.Stanza.Height(pdf), tr(line.Text))
 	}
 
@@ -394,6 +437,15 @@ func WriteSongPDF(song *Song) (*bytes.Buffer, error) {
 	pdf.WriteAligned(0, fonts.Title.Height(pdf), song.Title, "C")
 	pdf.Ln(fonts.Title.Height(pdf))
 
+	//Print subtitles and credits
+	setFont(pdf, fonts.Section)
+	for _, s := range append(append([]string{}, song.Subtitles...), song.Byline()) {
+		if len(s) > 0 {
+			pdf.WriteAligned(0, fonts.Section.Height(pdf), s, "C")
+			pdf.Ln(fonts.Section.Height(pdf))
+		}
+	}
+
 	//Print section
 	if len(song.Section) > 0 {
 		setFont(pdf, fonts.Section)
@@ -438,13 +490,29 @@ func println(pdf *gofpdf.Fpdf, tr func(string) string, str string, font PDFFont)
 	return w, h
 }
 
-func printlnSlice(pdf *gofpdf.Fpdf, tr func(string) string, slice []string, font PDFFont) {
-	for _, s := range slice {
-		println(pdf, tr, s, font)
+//printComments prints each Comment on its own line,
+//boxed comments are printed with a border.
+func printComments(pdf *gofpdf.Fpdf, tr func(string) string, comments []Comment, font PDFFont) {
+	for _, c := range comments {
+		if c.Style != CommentBox {
+			println(pdf, tr, c.Text, font)
+			continue
+		}
+
+		setFont(pdf, font)
+		h := font.Height(pdf)
+		pdf.CellFormat(pdf.GetStringWidth(tr(c.Text))+2, h, tr(c.Text), "1", 1, "C", false, 0, "")
 	}
 }
 
-func (song Song) getHeight(pdf *gofpdf.Fpdf, fonts BookFonts) float64 {
+//verbatimFont returns the fixed-width font used for tabs and grids.
+func (fonts BookFonts) verbatimFont() PDFFont {
+	return PDFFont{"Courier", "", fonts.Chord.Size}
+}
+
+//getHeight returns the height of the Song as printSong prints it, in one
+//column or, with <two_columns>, in one of two columns.
+func (song Song) getHeight(pdf *gofpdf.Fpdf, fonts BookFonts, two_columns bool) float64 {
 	//title and section
 	h := fonts.Title.Height(pdf) + fonts.Stanza.Height(pdf)
 	if len(song.Section) > 0 {
@@ -452,9 +520,9 @@ func (song Song) getHeight(pdf *gofpdf.Fpdf, fonts BookFonts) float64 {
 	}
 
 	h += fonts.SongNumber.Height(pdf)
-	h += fonts.Comment.Height(pdf) * (float64)(len(song.BeforeComments)+len(song.AfterComments))
+	h += fonts.Comment.Height(pdf) * (float64)(len(song.getBeforeComments())+len(song.getAfterComments()))
 	//before comments also have a blank line after
-	if song.HasBeforeComments() {
+	if len(song.getBeforeComments()) > 0 {
 		h += fonts.Comment.Height(pdf)
 	}
 
@@ -465,11 +533,45 @@ func (song Song) getHeight(pdf *gofpdf.Fpdf, fonts BookFonts) float64 {
 		h += fonts.Stanza.Height(pdf)
 	}
 
+	colWidth := width
+	if two_columns {
+		colWidth = width / 2
+	}
+	h += diagramsHeight(pdf, song.Diagrams(), fonts, colWidth)
+
 	return h
 }
 
+//getBeforeComments returns the comments printed before the Song,
+//including the capo position if one is set.
+func (song Song) getBeforeComments() []Comment {
+	if song.GetCapo() == 0 {
+		return song.BeforeComments
+	}
+
+	capo := Comment{Text: "Capo " + strconv.Itoa(song.GetCapo())}
+	return append([]Comment{capo}, song.BeforeComments...)
+}
+
+//getAfterComments returns the comments printed after the Song,
+//including the copyright if one is set.
+func (song Song) getAfterComments() []Comment {
+	if len(song.Copyright) == 0 {
+		return song.AfterComments
+	}
+
+	copyright := Comment{Text: "© " + song.Copyright}
+	return append(append([]Comment{}, song.AfterComments...), copyright)
+}
+
 func (stanza Stanza) getHeight(pdf *gofpdf.Fpdf, fonts BookFonts) float64 {
 	h := fonts.Comment.Height(pdf) * (float64)(len(stanza.BeforeComments)+len(stanza.AfterComments))
+	if len(stanza.GetLabel()) > 0 {
+		h += fonts.Comment.Height(pdf)
+	}
+	if stanza.IsVerbatim() {
+		return h + fonts.verbatimFont().Height(pdf)*(float64)(len(stanza.Lines)) + fonts.Stanza.Height(pdf)
+	}
 	if stanza.HasChords() {
 		h += fonts.Chord.Height(pdf) * (float64)(len(stanza.Lines))
 	}
@@ -478,6 +580,125 @@ func (stanza Stanza) getHeight(pdf *gofpdf.Fpdf, fonts BookFonts) float64 {
 	return h
 }
 
+//diagramSize returns the width and height taken by a chord diagram for an
+//instrument with <strs> strings, and the space between each string.
+func diagramSize(pdf *gofpdf.Fpdf, fonts BookFonts, strs int) (w, h, spacing float64) {
+	spacing = fonts.Chord.Height(pdf) / 2
+	w = spacing * float64(strs+2)
+	h = fonts.Chord.Height(pdf) + spacing*float64(diagramFrets+2)
+
+	return w, h, spacing
+}
+
+//diagramsPerRow returns the number of diagrams that fit across <colWidth>.
+func diagramsPerRow(diagramWidth float64, colWidth float64) int {
+	perRow := int(colWidth / diagramWidth)
+	if perRow < 1 {
+		return 1
+	}
+
+	return perRow
+}
+
+func diagramsHeight(pdf *gofpdf.Fpdf, diagrams []ChordDiagram, fonts BookFonts, colWidth float64) float64 {
+	if len(diagrams) == 0 {
+		return 0
+	}
+
+	w, h, _ := diagramSize(pdf, fonts, len(diagrams[0].Shape.Frets))
+	perRow := diagramsPerRow(w, colWidth-fonts.StanzaIndent)
+	rows := (len(diagrams) + perRow - 1) / perRow
+
+	return float64(rows) * h
+}
+
+//printDiagrams prints the given chord diagrams in rows across the column,
+//moving to the next column or page when a row does not fit.
+func printDiagrams(pdf *gofpdf.Fpdf, tr func(string) string, diagrams []ChordDiagram, fonts BookFonts, two_columns bool) {
+	if len(diagrams) == 0 {
+		return
+	}
+
+	colWidth := width
+	if two_columns {
+		colWidth = width / 2
+	}
+
+	w, h, spacing := diagramSize(pdf, fonts, len(diagrams[0].Shape.Frets))
+	perRow := diagramsPerRow(w, colWidth-fonts.StanzaIndent)
+
+	for i, diagram := range diagrams {
+		if i%perRow == 0 {
+			if i > 0 {
+				pdf.SetY(pdf.GetY() + h)
+			}
+
+			if pdf.GetY()+h >= height {
+				if two_columns {
+					nextCol(pdf, fonts)
+				} else {
+					newPage(pdf, fonts)
+				}
+			}
+		}
+
+		x := xMargin + fonts.StanzaIndent + float64(i%perRow)*w
+		printDiagram(pdf, tr, diagram, x, pdf.GetY(), spacing, fonts)
+	}
+
+	pdf.SetY(pdf.GetY() + h)
+}
+
+//printDiagram draws a single chord diagram with its top left corner at x, y.
+func printDiagram(pdf *gofpdf.Fpdf, tr func(string) string, diagram ChordDiagram, x, y, spacing float64, fonts BookFonts) {
+	strs := len(diagram.Shape.Frets)
+	base := diagram.Shape.BaseFret()
+	left := x + spacing
+	right := left + spacing*float64(strs-1)
+
+	setFont(pdf, fonts.Chord)
+	pdf.SetXY(x, y)
+	pdf.CellFormat(spacing*float64(strs+1), fonts.Chord.Height(pdf), tr(diagram.Name), "", 0, "C", false, 0, "")
+
+	top := y + fonts.Chord.Height(pdf) + spacing
+	bottom := top + spacing*diagramFrets
+
+	pdf.SetLineWidth(0.2)
+	for i := 0; i < strs; i++ {
+		sx := left + spacing*float64(i)
+		pdf.Line(sx, top, sx, bottom)
+	}
+	for i := 0; i <= diagramFrets; i++ {
+		fy := top + spacing*float64(i)
+		pdf.Line(left, fy, right, fy)
+	}
+
+	if base == 1 {
+		pdf.SetLineWidth(0.8)
+		pdf.Line(left, top, right, top)
+		pdf.SetLineWidth(0.2)
+	} else {
+		setFont(pdf, PDFFont{fonts.Chord.Family, "", fonts.Chord.Size * 0.6})
+		pdf.Text(x, top+spacing*0.75, strconv.Itoa(base))
+	}
+
+	r := spacing / 3
+	for i, f := range diagram.Shape.Frets {
+		sx := left + spacing*float64(i)
+		switch {
+		case f < 0:
+			pdf.Line(sx-r, top-spacing+r/2, sx+r, top-r/2)
+			pdf.Line(sx-r, top-r/2, sx+r, top-spacing+r/2)
+		case f == 0:
+			pdf.Circle(sx, top-spacing/2, r, "D")
+		default:
+			pdf.Circle(sx, top+spacing*(float64(f-base)+0.5), r, "F")
+		}
+	}
+
+	pdf.SetXY(x, y)
+}
+
 func (font PDFFont) Height(pdf *gofpdf.Fpdf) float64 {
 	return pdf.PointConvert(font.Size)
 }
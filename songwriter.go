@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//WriteSongText writes the given Song back out in .song (ChordPro) format.
+//The Song is written as it is displayed, i.e. with any transposition, capo
+//and naming system applied, so a transposed Song can be saved as a new file.
+//Chords are always written as letters at concert pitch.
+//Reading the written text with ParseSong gives the same Song again.
+func WriteSongText(song *Song) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+
+	naming := song.GetNaming()
+	if naming == NamingDefault {
+		naming = song.Naming
+	}
+
+	//Song header
+	header := new(bytes.Buffer)
+	if !song.titleInferred && len(song.Title) > 0 {
+		writeDirective(header, "title", song.Title)
+	}
+	for _, s := range song.Subtitles {
+		writeDirective(header, "subtitle", s)
+	}
+	for _, a := range song.Artists {
+		writeDirective(header, "artist", a)
+	}
+	for _, c := range song.Composers {
+		writeDirective(header, "composer", c)
+	}
+	for _, l := range song.Lyricists {
+		writeDirective(header, "lyricist", l)
+	}
+	writeValue(header, "copyright", song.Copyright)
+	writeValue(header, "album", song.Album)
+	writeValue(header, "year", song.Year)
+	writeValue(header, "section", song.Section)
+	if naming != NamingDefault {
+		writeDirective(header, "naming", naming.String())
+	}
+	if !song.keyInferred && song.DisplayKey().IsValid() {
+		writeDirective(header, "key", song.DisplayKey().Name(naming))
+	}
+	writeValue(header, "time", song.Time)
+	writeValue(header, "tempo", song.Tempo)
+	writeValue(header, "duration", song.Duration)
+	if song.GetCapo() > 0 {
+		writeDirective(header, "capo", strconv.Itoa(song.GetCapo()))
+	}
+
+	//other meta data and chord definitions, sorted so the output is stable
+	metaNames := make([]string, 0)
+	for name := range song.Meta {
+		metaNames = append(metaNames, name)
+	}
+	sort.Strings(metaNames)
+	for _, name := range metaNames {
+		for _, value := range song.Meta[name] {
+			if strings.HasPrefix(name, "x_") {
+				writeDirective(header, name, value)
+			} else {
+				writeDirective(header, "meta", strings.TrimSpace(name+" "+value))
+			}
+		}
+	}
+
+	defineNames := make([]string, 0)
+	for name := range song.Defines {
+		defineNames = append(defineNames, name)
+	}
+	sort.Strings(defineNames)
+	for _, name := range defineNames {
+		writeDirective(header, "define", name+" "+song.Defines[name].defineText())
+	}
+
+	if !song.ShowStanzaNumbers {
+		writeDirective(header, "no_number", "")
+	}
+	writeComments(header, song.BeforeComments)
+
+	buf.Write(header.Bytes())
+
+	//a blank line ends the header, which is needed if the first stanza
+	//starts with something that would otherwise belong to the song
+	if header.Len() > 0 || len(song.Stanzas) == 0 && len(song.AfterComments) > 0 ||
+		len(song.Stanzas) > 0 && (!song.Stanzas[0].ShowNumber || len(song.Stanzas[0].BeforeComments) > 0) {
+		buf.WriteString("\n")
+	}
+
+	for i, stanza := range song.Stanzas {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+
+		writeStanza(buf, stanza)
+	}
+
+	if len(song.AfterComments) > 0 {
+		if len(song.Stanzas) > 0 {
+			buf.WriteString("\n")
+		}
+		writeComments(buf, song.AfterComments)
+	}
+
+	return buf, nil
+}
+
+//writeStanza writes a single Stanza, with any section directives needed.
+func writeStanza(buf *bytes.Buffer, stanza Stanza) {
+	if stanza.NewPage {
+		writeDirective(buf, "new_page", "")
+	}
+	if stanza.ColumnBreak {
+		writeDirective(buf, "column_break", "")
+	}
+	if !stanza.ShowNumber {
+		writeDirective(buf, "no_number", "")
+	}
+
+	if stanza.Recall {
+		writeComments(buf, stanza.BeforeComments)
+		writeDirective(buf, "chorus", stanza.Label)
+		return
+	}
+
+	section := stanza.sectionName()
+	if len(section) > 0 {
+		writeDirective(buf, "start_of_"+section, stanza.Label)
+	}
+
+	writeComments(buf, stanza.BeforeComments)
+
+	for _, line := range stanza.Lines {
+		if stanza.IsVerbatim() {
+			buf.WriteString(line.Text + "\n")
+		} else {
+			buf.WriteString(line.sourceText() + "\n")
+		}
+	}
+
+	writeComments(buf, stanza.AfterComments)
+
+	if len(section) > 0 {
+		writeDirective(buf, "end_of_"+section, "")
+	}
+}
+
+//sectionName returns the name used in the start_of_<section> directive for
+//this Stanza, or "" if the Stanza is a plain verse.
+func (stanza Stanza) sectionName() string {
+	if stanza.IsChorus {
+		return "chorus"
+	}
+
+	switch stanza.Kind {
+	case StanzaBridge:
+		return "bridge"
+	case StanzaTab:
+		return "tab"
+	case StanzaGrid:
+		return "grid"
+	}
+
+	if len(stanza.Label) > 0 {
+		return "verse"
+	}
+
+	return ""
+}
+
+//sourceText returns this Line as it is written in a .song file,
+//i.e. with the chords in brackets and the echo tag.
+func (line Line) sourceText() string {
+	var buf bytes.Buffer
+
+	//positions count characters, not bytes
+	text := []rune(line.Text)
+
+	echo := line.EchoIndex
+	if echo > len(text) {
+		echo = -1
+	}
+
+	c := 0
+	for i := 0; i <= len(text); i++ {
+		if i == echo {
+			buf.WriteString("{echo: ")
+		}
+
+		for c < len(line.Chords) && line.Chords[c].Position <= i {
+			buf.WriteString("[" + line.Chords[c].ConcertText() + "]")
+			c++
+		}
+
+		if i < len(text) {
+			buf.WriteRune(text[i])
+		}
+	}
+
+	//chords past the end of the text
+	for ; c < len(line.Chords); c++ {
+		buf.WriteString("[" + line.Chords[c].ConcertText() + "]")
+	}
+
+	if echo >= 0 {
+		buf.WriteString("}")
+	}
+
+	return buf.String()
+}
+
+//defineText returns the shape as written in a {define} directive,
+//e.g. "base-fret 1 frets x 0 2 2 3 0".
+func (shape ChordShape) defineText() string {
+	base := shape.BaseFret()
+	frets := make([]string, len(shape.Frets))
+	for i, f := range shape.Frets {
+		switch {
+		case f < 0:
+			frets[i] = "x"
+		case f == 0:
+			frets[i] = "0"
+		default:
+			frets[i] = strconv.Itoa(f - base + 1)
+		}
+	}
+
+	return fmt.Sprintf("base-fret %d frets %s", base, strings.Join(frets, " "))
+}
+
+//writeComments writes each Comment with the directive for its style.
+func writeComments(buf *bytes.Buffer, comments []Comment) {
+	for _, c := range comments {
+		switch c.Style {
+		case CommentItalic:
+			writeDirective(buf, "comment_italic", c.Text)
+		case CommentBox:
+			writeDirective(buf, "comment_box", c.Text)
+		default:
+			writeDirective(buf, "comments", c.Text)
+		}
+	}
+}
+
+//writeDirective writes the directive {name: value} on its own line,
+//or {name} if the directive has no value and does not need one, so
+//directives with an empty value, e.g. {comment:}, are kept.
+func writeDirective(buf *bytes.Buffer, name string, value string) {
+	if len(value) == 0 {
+		switch name {
+		case "new_page", "column_break", "no_number", "chorus", "start_of_verse",
+			"start_of_chorus", "start_of_bridge", "start_of_tab", "start_of_grid",
+			"end_of_verse", "end_of_chorus", "end_of_bridge", "end_of_tab", "end_of_grid":
+			buf.WriteString("{" + name + "}\n")
+		default:
+			if strings.HasPrefix(name, "x_") {
+				buf.WriteString("{" + name + "}\n")
+			} else {
+				buf.WriteString("{" + name + ":}\n")
+			}
+		}
+		return
+	}
+
+	buf.WriteString("{" + name + ": " + value + "}\n")
+}
+
+//writeValue writes the directive {name: value} for meta data that is
+//unset when empty, e.g. the copyright, so nothing is written without a value.
+func writeValue(buf *bytes.Buffer, name string, value string) {
+	if len(value) > 0 {
+		writeDirective(buf, name, value)
+	}
+}
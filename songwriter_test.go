@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var roundTripTests = []string{
+	"Amazing [G]grace, how [C]sweet the [G]sound\nThat saved a [D]wretch like me\n",
+	`{title: Full Song}
+{st: Subtitle}
+{artist: Someone}
+{copyright: 2020}
+{key: G}
+{capo: 2}
+{meta: arranger Someone Else}
+{define: Asus4 base-fret 1 frets x 0 2 2 3 0}
+{no_number}
+{c: Before the song}
+
+{ci: Before verse 1}
+[G]Line one {echo: [D]line one}
+{cb: After verse 1}
+
+{no_number}
+{soc: Refrain}
+[C]Chorus line
+{eoc}
+
+{np}
+{chorus}
+
+{sot}
+e|---0---|
+
+B|---1---|
+{eot}
+
+{start_of_verse: Verse 2}
+[G]Last line[D]
+{end_of_verse}
+
+{c: After the song}
+`,
+	"{naming: german}\n{key: H}\n[H]Eins [Fis]zwei [B]drei\n",
+	"{c: Only comments}\n\n{c: After}\n",
+	"{st:}\n{meta: arranger}\n{c:}\n\n{ci:}\nLine one\n{cb:}\n",
+	"{no_number}\nLine [G]with chords [Am]\n",
+	"‘Tis [G]so sweet to [C]trust in [G]Jesus’\nWhakaaria [G]mai tōu [C]rīpeka {echo: [D]ki ā-au}\n",
+}
+
+func TestWriteSongTextRoundTrip(t *testing.T) {
+	for _, in := range roundTripTests {
+		song, diags, _ := ParseSong(strings.NewReader(in), ParseOptions{Filename: "test.song"})
+		if len(diags) > 0 {
+			t.Errorf("%q: unexpected diagnostics %v", in, diags)
+		}
+
+		out, _ := WriteSongText(song)
+		again, _, _ := ParseSong(strings.NewReader(out.String()), ParseOptions{Filename: "test.song"})
+
+		if !reflect.DeepEqual(song, again) {
+			t.Errorf("%q: round trip changed the song, written as:\n%s", in, out)
+		}
+
+		//writing is stable
+		out2, _ := WriteSongText(again)
+		if out.String() != out2.String() {
+			t.Errorf("%q: expected\n%s\nactual\n%s", in, out, out2)
+		}
+	}
+}
+
+func TestWriteSongTextTransposed(t *testing.T) {
+	song, _, _ := ParseSong(strings.NewReader("{key: G}\n[G]Line [D/F#]one {echo: [Em]echo}\n"),
+		ParseOptions{Filename: "test.song"})
+	song.TransposeToKey(Key{Tonic: Note{'B', -1}})
+
+	out, _ := WriteSongText(song)
+	expected := "{key: Bb}\n\n[Bb]Line [F/A]one {echo: [Gm]echo}\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%s\nactual\n%s", expected, out)
+	}
+}
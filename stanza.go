@@ -3,15 +3,66 @@ package main
 //Stanza represents an individual stanza of a song, including the chorus.
 //Essentially a Stanza is a collection of Lines, along with
 //Comments that appear before and after the Stanza.
+//Kind marks verses, bridges, tabs and grids, choruses are marked with IsChorus.
+//A chorus recall (i.e. "sing the chorus again") is a chorus with Recall set
+//and no Lines. Label is an optional name for the Stanza, e.g. "Verse 1".
+//NewPage and ColumnBreak start the Stanza on a new page or column when printed.
 type Stanza struct {
 	ShowNumber     bool
 	IsChorus       bool
+	Recall         bool
+	Kind           StanzaKind
+	Label          string
 	Number         int
-	BeforeComments []string
-	AfterComments  []string
+	NewPage        bool
+	ColumnBreak    bool
+	BeforeComments []Comment
+	AfterComments  []Comment
 	Lines          []Line
 }
 
+//StanzaKind is the kind of section a Stanza belongs to.
+type StanzaKind int
+
+const (
+	StanzaVerse  StanzaKind = 0
+	StanzaBridge StanzaKind = 1
+	//Tab and grid Lines are kept as written, without chords or echoes
+	StanzaTab  StanzaKind = 2
+	StanzaGrid StanzaKind = 3
+)
+
+//CommentStyle is the way a Comment is displayed.
+type CommentStyle int
+
+const (
+	CommentNormal CommentStyle = 0
+	CommentItalic CommentStyle = 1
+	CommentBox    CommentStyle = 2
+)
+
+//Comment is a comment line in a Song, e.g. "Capo 1" or "Repeat last line".
+type Comment struct {
+	Text  string
+	Style CommentStyle
+}
+
+func (comment Comment) String() string {
+	return comment.Text
+}
+
+//Class returns the CSS class used to display this Comment.
+func (comment Comment) Class() string {
+	switch comment.Style {
+	case CommentItalic:
+		return "comment comment-italic"
+	case CommentBox:
+		return "comment comment-box"
+	}
+
+	return "comment"
+}
+
 //HasChords returns true if any Lines in this Stanza have any Chords,
 //false otherwise.
 func (stanza Stanza) HasChords() bool {
@@ -23,3 +74,25 @@ func (stanza Stanza) HasChords() bool {
 
 	return false
 }
+
+//IsVerbatim returns true if this Stanza's Lines are displayed as written in
+//a fixed-width font (i.e. tabs and grids), false otherwise.
+func (stanza Stanza) IsVerbatim() bool {
+	return stanza.Kind == StanzaTab || stanza.Kind == StanzaGrid
+}
+
+//IsNumbered returns true if this Stanza is shown with its Number,
+//false otherwise. Only verses are numbered.
+func (stanza Stanza) IsNumbered() bool {
+	return stanza.ShowNumber && !stanza.IsChorus && stanza.Kind == StanzaVerse
+}
+
+//GetLabel returns the label shown before this Stanza, "Chorus" for a
+//chorus recall without a label of its own.
+func (stanza Stanza) GetLabel() string {
+	if stanza.Recall && len(stanza.Label) == 0 {
+		return "Chorus"
+	}
+
+	return stanza.Label
+}
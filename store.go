@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+//ErrInvalidName is the error for a song or songbook name that cannot be used
+//as a file name, e.g. one with a path in it such as "../secret".
+var ErrInvalidName = errors.New("invalid name")
+
+//ErrChanged is the error saving a file that someone else has changed since
+//it was read.
+var ErrChanged = errors.New("changed since it was read")
+
+//maxNameLength is the longest song or songbook name, so that the file name
+//(with its extension and a temporary suffix while saving) is not too long.
+const maxNameLength = 200
+
+//fileStore is a directory of song or songbook files. Every file written or
+//removed is named by a validated name, so is always inside the directory,
+//and files are written atomically, so readers never see half a file.
+type fileStore struct {
+	Kind string
+	Ext  string
+	Root func() string
+}
+
+//songStore and bookStore are the songs and books directories.
+var (
+	songStore = fileStore{"song", ".song", func() string { return songs_root }}
+	bookStore = fileStore{"songbook", ".songlist", func() string { return books_root }}
+)
+
+//StoreError is an error reading or writing the file of a song or songbook.
+//Its message is safe to show to the user; Err (e.g. with the file's path)
+//is not.
+type StoreError struct {
+	Op   string
+	Kind string
+	Name string
+	Err  error
+}
+
+func (e *StoreError) Error() string {
+	switch {
+	case e.Err == ErrInvalidName:
+		return fmt.Sprintf("invalid %s name %q", e.Kind, e.Name)
+	case os.IsNotExist(e.Err):
+		return fmt.Sprintf("%s %q not found", e.Kind, e.Name)
+	case os.IsExist(e.Err):
+		return fmt.Sprintf("%s %q already exists", e.Kind, e.Name)
+	case e.Err == ErrDeletedRevision:
+		return fmt.Sprintf("cannot %s %s %q: %s", e.Op, e.Kind, e.Name, e.Err)
+	case e.Err == ErrChanged:
+		return fmt.Sprintf("%s %q has been changed by someone else", e.Kind, e.Name)
+	}
+
+	return fmt.Sprintf("could not %s %s %q", e.Op, e.Kind, e.Name)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+//Status returns the HTTP status code for the error.
+func (e *StoreError) Status() int {
+	switch {
+	case e.Err == ErrInvalidName, e.Err == ErrDeletedRevision:
+		return http.StatusBadRequest
+	case os.IsNotExist(e.Err):
+		return http.StatusNotFound
+	case os.IsExist(e.Err), e.Err == ErrChanged:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+//storeStatus returns the HTTP status code for an error from a fileStore.
+func storeStatus(err error) int {
+	var se *StoreError
+	if errors.As(err, &se) {
+		return se.Status()
+	}
+
+	return http.StatusInternalServerError
+}
+
+//validName returns true if the name can be used as a song or songbook file
+//name: it has no path in it, does not start with a dot (so is not hidden,
+//or "..") and has no control characters or surrounding spaces.
+//":" is not allowed either, as it is a drive letter on Windows.
+func validName(name string) bool {
+	if len(name) == 0 || len(name) > maxNameLength || name != strings.TrimSpace(name) {
+		return false
+	}
+
+	if !utf8.ValidString(name) || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\:`) {
+		return false
+	}
+
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//Path returns the path of the named file, or an error if the name is not valid.
+func (s fileStore) Path(name string) (string, error) {
+	if !validName(name) {
+		return "", &StoreError{"use", s.Kind, name, ErrInvalidName}
+	}
+
+	root := filepath.Clean(s.Root())
+	path := filepath.Join(root, name+s.Ext)
+
+	//validName should make this impossible, but never leave the directory
+	if filepath.Dir(path) != root {
+		return "", &StoreError{"use", s.Kind, name, ErrInvalidName}
+	}
+
+	return path, nil
+}
+
+//Read returns the content of the named file.
+func (s fileStore) Read(name string) ([]byte, error) {
+	path, err := s.Path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, &StoreError{"read", s.Kind, name, err}
+	}
+
+	return b, nil
+}
+
+//Write saves the content to the named file, replacing it if it exists.
+func (s fileStore) Write(name string, content []byte) error {
+	path, err := s.Path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(path, content, 0644, true); err != nil {
+		return &StoreError{"save", s.Kind, name, err}
+	}
+
+	return nil
+}
+
+//Create saves the content to the named file, which must not already exist.
+func (s fileStore) Create(name string, content []byte) error {
+	path, err := s.Path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(path, content, 0644, false); err != nil {
+		return &StoreError{"create", s.Kind, name, err}
+	}
+
+	return nil
+}
+
+//Remove deletes the named file.
+func (s fileStore) Remove(name string) error {
+	path, err := s.Path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return &StoreError{"delete", s.Kind, name, err}
+	}
+
+	return nil
+}
+
+//Version returns a token for the content of the named file, which changes
+//whenever the content does, or "" if the file does not exist.
+func (s fileStore) Version(name string) (string, error) {
+	b, err := s.Read(name)
+	if os.IsNotExist(errors.Unwrap(err)) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return contentVersion(b), nil
+}
+
+//contentVersion returns the Version of a file with the given content.
+func contentVersion(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:16])
+}
+
+//saveMu is held while checking a file's Version and saving it, so two
+//saves of the same version cannot both succeed.
+var saveMu sync.Mutex
+
+//SaveIfUnchanged saves the named file (see Save), but only if the file
+//<base> (usually the same one) still has the given Version, i.e. no one
+//else has changed it since it was read. Otherwise the error is ErrChanged.
+func (s fileStore) SaveIfUnchanged(name string, content []byte, change Change, base string, version string) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	current, err := s.Version(base)
+	if err != nil {
+		return err
+	}
+	if current != version {
+		return &StoreError{"save", s.Kind, base, ErrChanged}
+	}
+
+	return s.Save(name, content, change)
+}
+
+//writeFileAtomic writes the content to a temporary file beside <path>, then
+//moves it into place, so <path> is either unchanged or completely written.
+//With <replace> false, an existing file is not replaced, and an error
+//satisfying os.IsExist is returned.
+//A symlink at <path> is replaced, rather than the file it points to.
+func writeFileAtomic(path string, content []byte, perm os.FileMode, replace bool) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(content); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	if replace {
+		return os.Rename(tmp.Name(), path)
+	}
+
+	//a hard link fails if the file exists, so two creates cannot both succeed
+	if err = os.Link(tmp.Name(), path); err != nil {
+		if os.IsExist(err) {
+			return err
+		}
+
+		//the file system may not have hard links
+		if _, statErr := os.Lstat(path); statErr == nil {
+			return &os.PathError{Op: "create", Path: path, Err: os.ErrExist}
+		}
+		if err = os.Rename(tmp.Name(), path); err != nil {
+			return err
+		}
+	}
+
+	os.Remove(tmp.Name())
+	return nil
+}
+
+//storeHTTPError responds with the message and status of an error from a
+//fileStore, logging the cause of unexpected errors.
+func storeHTTPError(w http.ResponseWriter, err error) {
+	status := storeStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Println(errors.Unwrap(err))
+	}
+
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var validNameTests = []struct {
+	name     string
+	expected bool
+}{
+	{"amazing", true},
+	{"Amazing Grace (2)", true},
+	{"whakaaria-mai_tōu", true},
+	{"", false},
+	{".hidden", false},
+	{"..", false},
+	{"../secret", false},
+	{"songs/amazing", false},
+	{`..\secret`, false},
+	{"C:secret", false},
+	{" amazing", false},
+	{"amazing\n", false},
+	{"amazing\x00", false},
+	{"bad\xffutf8", false},
+	{strings.Repeat("a", maxNameLength), true},
+	{strings.Repeat("a", maxNameLength+1), false},
+}
+
+func TestValidName(t *testing.T) {
+	for _, vt := range validNameTests {
+		if actual := validName(vt.name); actual != vt.expected {
+			t.Errorf("validName(%q): expected %t, actual %t", vt.name, vt.expected, actual)
+		}
+	}
+}
+
+//newTestStore returns a fileStore of ".song" files in a temporary directory.
+func newTestStore(t *testing.T) (fileStore, string) {
+	dir, err := ioutil.TempDir("", "isb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(dir, "songs")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return fileStore{"song", ".song", func() string { return root }}, dir
+}
+
+func TestFileStore(t *testing.T) {
+	s, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+
+	if err := s.Create("a", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create("a", []byte("two")); storeStatus(err) != http.StatusConflict {
+		t.Errorf("expected creating an existing song to conflict, actual %v", err)
+	}
+	if err := s.Write("a", []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := s.Read("a"); err != nil || string(b) != "three" {
+		t.Errorf("expected %q, actual %q (%v)", "three", b, err)
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, "songs", "a.song")); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("expected a.song with mode 0644, actual %v (%v)", info, err)
+	}
+
+	//no temporary files are left behind
+	if files, _ := ioutil.ReadDir(filepath.Join(dir, "songs")); len(files) != 1 {
+		t.Errorf("expected only a.song, actual %d files", len(files))
+	}
+
+	if err := s.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove("a"); storeStatus(err) != http.StatusNotFound || err.Error() != `song "a" not found` {
+		t.Errorf("expected song not found, actual %v", err)
+	}
+	if _, err := s.Read("a"); storeStatus(err) != http.StatusNotFound {
+		t.Errorf("expected song not found, actual %v", err)
+	}
+
+	//nothing outside the directory can be changed
+	secret := filepath.Join(dir, "secret.song")
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"../secret", "..", "/tmp/secret", ""} {
+		if err := s.Write(name, []byte("changed")); storeStatus(err) != http.StatusBadRequest {
+			t.Errorf("write %q: expected an invalid name, actual %v", name, err)
+		}
+		if err := s.Remove(name); storeStatus(err) != http.StatusBadRequest {
+			t.Errorf("remove %q: expected an invalid name, actual %v", name, err)
+		}
+	}
+
+	if b, err := ioutil.ReadFile(secret); err != nil || string(b) != "secret" {
+		t.Errorf("expected the file outside the directory to be unchanged, actual %q (%v)", b, err)
+	}
+}
+
+var editHandlerTests = []struct {
+	method string
+	url    string
+	form   url.Values
+	status int
+	body   string
+}{
+	{"POST", "/song/a/edit", url.Values{"content": {`"{title: Changed}\nLine\n"`}}, 200, ""},
+	//"/" in a name does not match the routes, but other names reach the handlers
+	{"POST", "/song/..%2Fsecret/edit", url.Values{"content": {`"x"`}}, 404, ""},
+	{"POST", "/song/..%5Csecret/edit", url.Values{"content": {`"x"`}}, 400, `invalid song name "..\\secret"`},
+	{"POST", "/song/a/edit", url.Values{"content": {`not json`}}, 400, "invalid song content"},
+	{"GET", "/song/.hidden/edit", nil, 400, `invalid song name ".hidden"`},
+	{"GET", "/song/missing/edit", nil, 404, `song "missing" not found`},
+	{"POST", "/book/new/edit", url.Values{"settings": {`{"name":"new"}`}, "songs": {`["a"]`}}, 200, ""},
+	{"POST", "/book/x/edit", url.Values{"settings": {`{"name":"../secret"}`}, "songs": {`["a"]`}}, 400, `invalid songbook name "../secret"`},
+	{"POST", "/book/x/edit", url.Values{"settings": {`{"name":"x","index-pos":"end}\n{title: Bad"}`}, "songs": {`["a"]`}}, 400, "invalid value for index-pos"},
+	{"POST", "/book/x/edit", url.Values{"settings": {`{"name":"x"}`}, "songs": {`["a\n../secret"]`}}, 400, "invalid song"},
+	{"POST", "/book/x/edit", url.Values{"settings": {`{"name":"x"}`}, "songs": {`["a {key: G"]`}}, 400, "invalid song"},
+	{"POST", "/book/x/edit", url.Values{"songs": {`["a"]`}}, 400, "invalid songbook settings"},
+	{"DELETE", "/book/..%5Csecret/edit", nil, 400, "invalid songbook name"},
+	{"DELETE", "/book/missing/edit", nil, 404, `songbook "missing" not found`},
+	{"DELETE", "/book/new/edit", nil, 204, ""},
+	{"POST", "/song/b/lint", url.Values{"content": {`"{title: changed}\nLine\n"`}}, 200, `b.song: warning: title \"changed\" is also used by a.song [duplicate-title]`},
+	{"POST", "/song/a/lint", url.Values{"content": {`"{title: Changed}\nLine\n"`}}, 200, `[]`},
+	{"POST", "/song/unsaved/preview", url.Values{"content": {`"{title: Preview}\n[G]Line {echo: b} c\n"`}}, 200, `unsaved.song:2:18: error: text after the echo tag is not shown [echo]`},
+	{"POST", "/song/unsaved/preview", url.Values{"content": {`"{title: Preview}\n[G]Line\n"`}}, 200, `\u003cspan class='chord'\u003eG\u003c/span\u003e`},
+	{"POST", "/song/unsaved/preview", url.Values{"content": {`not json`}}, 400, "invalid song content"},
+	{"POST", "/song/unsaved/preview?format=pdf", url.Values{"content": {`{"title": "x"}`}}, 400, "invalid song content"},
+	{"POST", "/song/a/format", url.Values{"content": {`"{title:  Alpha}\nLine\n"`}}, 200, `"content":"{title: Alpha}\n\nLine\n"`},
+	{"POST", "/song/a/format", url.Values{"content": {`not json`}}, 400, "invalid song content"},
+	{"POST", "/song/a/lint", url.Values{}, 400, "invalid song content"},
+}
+
+func TestEditHandlers(t *testing.T) {
+	c, dir := newTestCatalog(t, map[string]string{"a.song": "{title: Alpha}\nLine\n"}, nil)
+	defer os.RemoveAll(dir)
+
+	oldCatalog, oldSongs, oldBooks := catalog, songs_root, books_root
+	catalog, songs_root, books_root = c, filepath.Join(dir, "songs"), filepath.Join(dir, "books")
+	defer func() {
+		catalog, songs_root, books_root = oldCatalog, oldSongs, oldBooks
+	}()
+
+	r := httprouter.New()
+	r.GET("/song/:song/edit", editSongHandler)
+	r.POST("/song/:song/edit", editSongPostHandler)
+	r.POST("/book/:book/edit", editBookPostHandler)
+	r.DELETE("/book/:book/edit", editBookDeleteHandler)
+	r.POST("/song/:song/preview", previewSongHandler)
+	r.POST("/song/:song/format", formatSongHandler)
+	r.POST("/song/:song/lint", lintSongHandler)
+
+	for _, et := range editHandlerTests {
+		req := httptest.NewRequest(et.method, et.url, strings.NewReader(et.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != et.status || !strings.Contains(rec.Body.String(), et.body) {
+			t.Errorf("%s %s: expected %d %q, actual %d %q", et.method, et.url, et.status, et.body, rec.Code, rec.Body)
+		}
+	}
+
+	if song, err := c.Song("a"); err != nil || song.Title != "Changed" {
+		t.Errorf("expected the edited song to be reloaded, actual %v (%v)", song, err)
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 2 {
+		t.Errorf("expected only the songs and books directories, actual %v", files)
+	}
+}
+
+func TestEditConflict(t *testing.T) {
+	original := "{title: Alpha}\nLine\n"
+	c, dir := newTestCatalog(t, map[string]string{"a.song": original}, nil)
+	defer os.RemoveAll(dir)
+
+	oldCatalog, oldSongs, oldBooks := catalog, songs_root, books_root
+	catalog, songs_root, books_root = c, filepath.Join(dir, "songs"), filepath.Join(dir, "books")
+	defer func() {
+		catalog, songs_root, books_root = oldCatalog, oldSongs, oldBooks
+	}()
+
+	r := httprouter.New()
+	r.POST("/song/:song/edit", editSongPostHandler)
+	r.POST("/book/:book/edit", editBookPostHandler)
+
+	post := func(url string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", url, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	//the first save of the original version succeeds, the second conflicts
+	if rec := post("/song/a/edit", url.Values{"content": {`"{title: Mine}\nLine\n"`}, "version": {contentVersion([]byte(original))}}); rec.Code != http.StatusOK {
+		t.Fatalf("expected the first save to succeed, actual %d %s", rec.Code, rec.Body)
+	}
+
+	rec := post("/song/a/edit", url.Values{"content": {`"{title: Theirs}\nLine\n"`}, "version": {contentVersion([]byte(original))}})
+	var conflict editConflict
+	if err := json.Unmarshal(rec.Body.Bytes(), &conflict); err != nil || rec.Code != http.StatusConflict {
+		t.Fatalf("expected a conflict, actual %d %s", rec.Code, rec.Body)
+	}
+
+	expected := editConflict{
+		Error:   `song "a" has been changed by someone else`,
+		Content: "{title: Mine}\nLine\n",
+		Version: contentVersion([]byte("{title: Mine}\nLine\n")),
+		Diff:    []DiffLine{{"-", "{title: Mine}"}, {"+", "{title: Theirs}"}, {" ", "Line"}},
+	}
+	if !reflect.DeepEqual(conflict, expected) {
+		t.Errorf("expected %v, actual %v", expected, conflict)
+	}
+
+	if song, err := c.Song("a"); err != nil || song.Title != "Mine" {
+		t.Errorf("expected the first save to be kept, actual %v (%v)", song, err)
+	}
+
+	//saving over the changes with their version succeeds
+	if rec := post("/song/a/edit", url.Values{"content": {`"{title: Theirs}\nLine\n"`}, "version": {conflict.Version}}); rec.Code != http.StatusOK {
+		t.Errorf("expected saving over the changes to succeed, actual %d %s", rec.Code, rec.Body)
+	}
+
+	//a new songbook has no version, so only the first to create it succeeds
+	book := url.Values{"settings": {`{"name":"new"}`}, "songs": {`["a"]`}, "version": {""}}
+	if rec := post("/book/new/edit", book); rec.Code != http.StatusOK {
+		t.Errorf("expected the songbook to be created, actual %d %s", rec.Code, rec.Body)
+	}
+	if rec := post("/book/new/edit", book); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), `"content":"a\n"`) {
+		t.Errorf("expected a conflict, actual %d %s", rec.Code, rec.Body)
+	}
+}
+
+var songFileTests = []struct {
+	method string
+	url    string
+	form   url.Values
+	status int
+	body   string
+}{
+	{"POST", "/songs/new", url.Values{"name": {"c"}, "title": {"Gamma"}}, 303, ""},
+	{"POST", "/songs/new", url.Values{"name": {"c"}}, 409, `song &#34;c&#34; already exists`},
+	{"POST", "/songs/new", url.Values{"name": {"../d"}, "title": {"Delta"}}, 400, `invalid song name`},
+	{"POST", "/songs/new", url.Values{"name": {"d"}, "title": {"Delta}"}}, 400, `invalid song title`},
+	{"POST", "/song/a/rename", url.Values{"name": {"z"}}, 200, `{"link":"z","books":["book"]}`},
+	{"POST", "/song/missing/rename", url.Values{"name": {"y"}}, 404, `song "missing" not found`},
+	{"POST", "/song/b/rename", url.Values{"name": {"c"}}, 409, `song "c" already exists`},
+	{"POST", "/song/b/rename", url.Values{"name": {".b"}}, 400, `invalid song name`},
+	{"DELETE", "/song/b/edit", nil, 409, `song "b" is in the songbooks book, other`},
+	{"DELETE", "/song/c/edit", nil, 204, ""},
+	{"DELETE", "/song/c/edit", nil, 404, `song "c" not found`},
+}
+
+func TestSongFileHandlers(t *testing.T) {
+	c, dir := newTestCatalog(t,
+		map[string]string{"a.song": "{title: Alpha}\nLine\n", "b.song": "{title: Beta}\nLine\n"},
+		map[string]string{"book.songlist": "{title: The Book}\n1,a {key: G}\nb.song\n", "other.songlist": "b\n"})
+	defer os.RemoveAll(dir)
+
+	oldCatalog, oldSongs, oldBooks := catalog, songs_root, books_root
+	catalog, songs_root, books_root = c, filepath.Join(dir, "songs"), filepath.Join(dir, "books")
+	defer func() {
+		catalog, songs_root, books_root = oldCatalog, oldSongs, oldBooks
+	}()
+
+	r := httprouter.New()
+	r.POST("/songs/new", newSongPostHandler)
+	r.POST("/song/:song/rename", renameSongHandler)
+	r.DELETE("/song/:song/edit", editSongDeleteHandler)
+
+	for _, st := range songFileTests {
+		req := httptest.NewRequest(st.method, st.url, strings.NewReader(st.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != st.status || !strings.Contains(rec.Body.String(), st.body) {
+			t.Errorf("%s %s: expected %d %q, actual %d %q", st.method, st.url, st.status, st.body, rec.Code, rec.Body)
+		}
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "books", "book.songlist")); err != nil || string(b) != "{title: The Book}\n1,z {key: G}\nb.song\n" {
+		t.Errorf("expected the songlist to have the renamed song, actual %q (%v)", b, err)
+	}
+
+	if _, err := c.Song("a"); err == nil {
+		t.Errorf("expected the renamed song to be gone")
+	}
+	if book, err := c.Book("book"); err != nil || book.Songs[1].Title != "Alpha" || book.Songs[1].Link() != "z" {
+		t.Errorf("expected the songbook to have the renamed song, actual %v (%v)", book, err)
+	}
+	if song, err := c.Song("b"); err != nil || song.Title != "Beta" {
+		t.Errorf("expected the song in songbooks not to be deleted, actual %v (%v)", song, err)
+	}
+}
+
+func TestRenameSongUndo(t *testing.T) {
+	songs := map[string]string{"a.song": "{title: Alpha}\nLine\n"}
+	books := map[string]string{"one.songlist": "a\n", "two.songlist": "a\n"}
+	c, dir := newTestCatalog(t, songs, books)
+	defer os.RemoveAll(dir)
+
+	oldCatalog, oldSongs, oldBooks := catalog, songs_root, books_root
+	catalog, songs_root, books_root = c, filepath.Join(dir, "songs"), filepath.Join(dir, "books")
+	defer func() {
+		catalog, songs_root, books_root = oldCatalog, oldSongs, oldBooks
+	}()
+	defer newTestHistory(dir)()
+
+	//the second songlist cannot be saved, as its history cannot be written
+	if err := os.MkdirAll(filepath.Join(dir, "history", "songlists", "two.songlist.history"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := renameSong("a", "z", Change{"ann", "Renamed"}); err == nil {
+		t.Fatalf("expected the rename to fail")
+	}
+
+	for name, expected := range map[string]string{
+		"songs/a.song":       songs["a.song"],
+		"books/one.songlist": books["one.songlist"],
+		"books/two.songlist": books["two.songlist"],
+	} {
+		if b, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || string(b) != expected {
+			t.Errorf("expected %s to be unchanged, actual %q (%v)", name, b, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "songs", "z.song")); !os.IsNotExist(err) {
+		t.Errorf("expected z.song to be removed, actual %v", err)
+	}
+
+	//with the songlist fixed, the rename can be tried again
+	if err := os.Remove(filepath.Join(dir, "history", "songlists", "two.songlist.history")); err != nil {
+		t.Fatal(err)
+	}
+	if books, err := renameSong("a", "z", Change{"ann", "Renamed"}); err != nil || len(books) != 2 {
+		t.Errorf("expected the rename to change both songlists, actual %v (%v)", books, err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+//fileState is what is compared to find out if a file has changed.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+//scanFiles returns the state of each file in <dir> with the extension <ext>
+//(e.g. ".song"), keyed by link, i.e. the file name without the extension.
+func scanFiles(dir string, ext string) (map[string]fileState, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]fileState)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(strings.ToLower(f.Name()), ext) {
+			continue
+		}
+
+		states[f.Name()[0:len(f.Name())-len(ext)]] = fileState{f.ModTime(), f.Size()}
+	}
+
+	return states, nil
+}
+
+//changedFiles returns the links of the files that were added, changed or
+//removed (a rename being both), in order.
+func changedFiles(before map[string]fileState, after map[string]fileState) []string {
+	changed := make([]string, 0)
+
+	for link, state := range after {
+		if old, ok := before[link]; !ok || !old.modTime.Equal(state.modTime) || old.size != state.size {
+			changed = append(changed, link)
+		}
+	}
+	for link := range before {
+		if _, ok := after[link]; !ok {
+			changed = append(changed, link)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+//scan records the state of the song and songbook files, for Sync to
+//compare with. The caller must hold syncMu.
+func (c *Catalog) scan() (songs map[string]fileState, books map[string]fileState, err error) {
+	songs, err = scanFiles(c.songsRoot, ".song")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	books, err = scanFiles(c.booksRoot, ".songlist")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return songs, books, nil
+}
+
+//Sync reloads the songs and songbooks whose files were added, changed,
+//renamed or deleted since they were last read.
+//A file that cannot be read does not stop the others being reloaded, and
+//is not read again until it changes; the first such error is returned.
+func (c *Catalog) Sync() error {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+
+	songs, books, err := c.scan()
+	if err != nil {
+		return err
+	}
+
+	changedSongs := changedFiles(c.songFiles, songs)
+	changedBooks := changedFiles(c.bookFiles, books)
+
+	var first error
+	if len(changedSongs) > 0 {
+		log.Printf("Reloading songs: %s", strings.Join(changedSongs, ", "))
+		first = c.ReloadSongs(changedSongs...)
+	}
+	if len(changedBooks) > 0 {
+		log.Printf("Reloading songbooks: %s", strings.Join(changedBooks, ", "))
+		if err := c.ReloadBooks(changedBooks...); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	c.songFiles = songs
+	c.bookFiles = books
+
+	return first
+}
+
+//Watch keeps the Catalog up to date with the files in its directories,
+//until <done> is closed. The files are watched with inotify where
+//available, otherwise (or with mode "poll") they are checked every
+//<interval>. Mode "off" does not watch the files.
+func (c *Catalog) Watch(mode string, interval time.Duration, done <-chan struct{}) error {
+	switch mode {
+	case "off":
+		return nil
+	case "auto":
+		events, err := watchDirs([]string{c.songsRoot, c.booksRoot}, done)
+		if err == nil {
+			go c.syncOnEvents(events, done)
+			return nil
+		}
+
+		log.Printf("Cannot watch files (%s), checking every %s instead", err, interval)
+	case "poll":
+	default:
+		return fmt.Errorf("unknown watch mode %q", mode)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := c.Sync(); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+//syncDelay is how long to wait after a file changes before reloading, so
+//that many changes (e.g. from a git pull) are reloaded together.
+const syncDelay = 100 * time.Millisecond
+
+//syncOnEvents syncs the Catalog each time there are events.
+func (c *Catalog) syncOnEvents(events <-chan struct{}, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		}
+
+		//wait for the changes to finish
+		for waiting := true; waiting; {
+			select {
+			case <-done:
+				return
+			case <-events:
+			case <-time.After(syncDelay):
+				waiting = false
+			}
+		}
+
+		if err := c.Sync(); err != nil {
+			log.Println(err)
+		}
+	}
+}
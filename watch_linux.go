@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+//watchEvents are the inotify events that may change a song or songbook.
+const watchEvents = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+//watchDirs watches the given directories with inotify, sending on the
+//returned channel whenever a .song or .songlist file in them changes,
+//until <done> is closed.
+func watchDirs(dirs []string, done <-chan struct{}) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	for _, dir := range dirs {
+		if _, err := syscall.InotifyAddWatch(fd, dir, watchEvents); err != nil {
+			syscall.Close(fd)
+			return nil, &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+		}
+	}
+
+	//a non-blocking file is read through the runtime poller, so closing it
+	//stops the read below
+	file := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-done
+		file.Close()
+	}()
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+
+			changed := false
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				name := string(buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)])
+				name = strings.ToLower(strings.TrimRight(name, "\x00"))
+				offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+				//the directory itself changed, or events were lost
+				if len(name) == 0 || strings.HasSuffix(name, ".song") || strings.HasSuffix(name, ".songlist") {
+					changed = true
+				}
+			}
+
+			if changed {
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
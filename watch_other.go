@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+//watchDirs is not supported, so the files are checked regularly instead.
+func watchDirs(dirs []string, done <-chan struct{}) (<-chan struct{}, error) {
+	return nil, errors.New("file watching is not supported on this system")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var changedFilesTests = []struct {
+	before   map[string]fileState
+	after    map[string]fileState
+	expected []string
+}{
+	{map[string]fileState{}, map[string]fileState{}, []string{}},
+	{map[string]fileState{"a": {time.Unix(1, 0), 1}}, map[string]fileState{"a": {time.Unix(1, 0), 1}}, []string{}},
+	{map[string]fileState{"a": {time.Unix(1, 0), 1}}, map[string]fileState{"a": {time.Unix(2, 0), 1}}, []string{"a"}},
+	{map[string]fileState{"a": {time.Unix(1, 0), 1}}, map[string]fileState{"a": {time.Unix(1, 0), 2}}, []string{"a"}},
+	{map[string]fileState{"a": {time.Unix(1, 0), 1}, "b": {time.Unix(1, 0), 1}},
+		map[string]fileState{"c": {time.Unix(1, 0), 1}, "b": {time.Unix(1, 0), 1}}, []string{"a", "c"}},
+	{nil, map[string]fileState{"a": {time.Unix(1, 0), 1}}, []string{"a"}},
+}
+
+func TestChangedFiles(t *testing.T) {
+	for _, ct := range changedFilesTests {
+		actual := changedFiles(ct.before, ct.after)
+		if !reflect.DeepEqual(actual, ct.expected) {
+			t.Errorf("%v -> %v: expected %v, actual %v", ct.before, ct.after, ct.expected, actual)
+		}
+	}
+}
+
+func TestCatalogSync(t *testing.T) {
+	c, dir := newTestCatalog(t,
+		map[string]string{"a.song": "{title: Alpha}\nLine\n"},
+		map[string]string{"book.songlist": "a\nb\n"})
+	defer os.RemoveAll(dir)
+
+	//a new song, which the songbook was waiting for
+	ioutil.WriteFile(filepath.Join(dir, "songs", "b.song"), []byte("{title: Beta}\nLine\n"), 0644)
+	//a renamed song
+	os.Rename(filepath.Join(dir, "songs", "a.song"), filepath.Join(dir, "songs", "c.song"))
+	//a new songbook
+	ioutil.WriteFile(filepath.Join(dir, "books", "new.songlist"), []byte("{title: New}\nc\n"), 0644)
+
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []DisplayList{{Link: "c", Title: "Alpha"}, {Link: "b", Title: "Beta"}}
+	if songs := c.Songs(); !reflect.DeepEqual(songs, expected) {
+		t.Errorf("expected songs %v, actual %v", expected, songs)
+	}
+
+	book, err := c.Book("book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(book.Songs) != 1 || book.Songs[1].Title != "Beta" {
+		t.Errorf("expected the songbook to have only Beta, actual %v", book.Songs)
+	}
+
+	if book, err := c.Book("new"); err != nil || book.Songs[1].Title != "Alpha" {
+		t.Errorf("expected the new songbook to have Alpha, actual %v (%v)", book, err)
+	}
+}
+
+func TestCatalogSyncUnreadable(t *testing.T) {
+	c, dir := newTestCatalog(t,
+		map[string]string{"a.song": "{title: Alpha}\nLine\n"},
+		map[string]string{"book.songlist": "a\nz\n"})
+	defer os.RemoveAll(dir)
+
+	//a song that cannot be read (a symlink to itself), beside changes that can
+	if err := os.Symlink("bad.song", filepath.Join(dir, "songs", "bad.song")); err != nil {
+		t.Skip(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "songs", "a.song"), []byte("{title: Changed}\nLine\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "songs", "z.song"), []byte("{title: Zeta}\nLine\n"), 0644)
+
+	if err := c.Sync(); err == nil {
+		t.Errorf("expected an error reading bad.song")
+	}
+
+	expected := []DisplayList{{Link: "a", Title: "Changed"}, {Link: "z", Title: "Zeta"}}
+	if songs := c.Songs(); !reflect.DeepEqual(songs, expected) {
+		t.Errorf("expected songs %v, actual %v", expected, songs)
+	}
+	if book, err := c.Book("book"); err != nil || len(book.Songs) != 2 {
+		t.Errorf("expected the songbook to be reloaded, actual %v (%v)", book, err)
+	}
+
+	//the unreadable file is not read again until it changes
+	if err := c.Sync(); err != nil {
+		t.Errorf("expected nothing to reload, actual %v", err)
+	}
+}
+
+func TestCatalogWatch(t *testing.T) {
+	for _, mode := range []string{"auto", "poll"} {
+		c, dir := newTestCatalog(t, map[string]string{"a.song": "{title: Alpha}\nLine\n"}, map[string]string{})
+		done := make(chan struct{})
+
+		if err := c.Watch(mode, 50*time.Millisecond, done); err != nil {
+			t.Fatal(err)
+		}
+
+		ioutil.WriteFile(filepath.Join(dir, "songs", "a.song"), []byte("{title: Changed}\nLine\n"), 0644)
+
+		changed := false
+		for i := 0; i < 100 && !changed; i++ {
+			time.Sleep(20 * time.Millisecond)
+			changed = c.Songs()[0].Title == "Changed"
+		}
+		if !changed {
+			t.Errorf("%s: expected the song to be reloaded", mode)
+		}
+
+		close(done)
+		os.RemoveAll(dir)
+	}
+
+	if err := NewCatalog("", "").Watch("sometimes", time.Second, nil); err == nil {
+		t.Errorf("expected an error for an unknown mode")
+	}
+}